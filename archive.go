@@ -0,0 +1,382 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"hash"
+	"io"
+	"sync/atomic"
+)
+
+// 区块归档文件格式:
+//
+//	magic(8字节) | header长度(4字节) | archiveHeader | blocks
+//
+// blocks为连续的 长度(4字节) | codec编码的archiveBlock，compressed为true时blocks整体使用gzip压缩。
+// checksum为未压缩的blocks部分的sha256
+const (
+	archiveMagic   = "C5JARCH\x00"
+	archiveVersion = 1
+
+	maxArchiveHeaderSize = 4 * 1024
+	maxArchiveBlockSize  = 64 * 1024 * 1024
+
+	archiveImportBatch = 128 // 每批送入导入流程的区块数
+	archiveSource      = "archive"
+)
+
+var (
+	errInvalidArchive  = errors.New("invalid block archive")
+	errArchiveChecksum = errors.New("block archive checksum mismatch")
+	errArchiveChain    = errors.New("block archive belongs to another chain")
+	errArchiveRange    = errors.New("invalid block archive range")
+)
+
+// archiveHeader 归档文件头
+type archiveHeader struct {
+	Version     uint32     // 格式版本
+	NetworkId   uint64     // 网络ID
+	GenesisHash types.Hash // 创世块hash
+	From        uint64     // 起始高度
+	To          uint64     // 结束高度(包含)
+	Compressed  bool       // 是否gzip压缩
+	Checksum    types.Hash // 未压缩区块数据的sha256
+}
+
+// archiveBlock 归档中的单个区块。models.Block无法直接用codec编码，按header及body分别编码
+type archiveBlock struct {
+	Header *models.Header
+	Body   *models.Body
+}
+
+// ExportChain 将[from,to]区间的区块导出到归档文件中
+func (s *syncer) ExportChain(w io.Writer, from, to uint64, compress bool) error {
+	if from > to || to > s.blockRW.CurrentBlock().Height() {
+		return fmt.Errorf("%w: from=%d to=%d", errArchiveRange, from, to)
+	}
+	// 第一遍计算校验值，避免将整个区间缓存在内存中
+	checksum := sha256.New()
+	if err := s.exportBlocks(checksum, from, to); err != nil {
+		return err
+	}
+	header := &archiveHeader{
+		Version:     archiveVersion,
+		NetworkId:   s.networkId,
+		GenesisHash: s.genesisHash(),
+		From:        from,
+		To:          to,
+		Compressed:  compress,
+	}
+	copy(header.Checksum[:], checksum.Sum(nil))
+
+	headerBytes, err := codec.Coder().Encode(header)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(archiveMagic); err != nil {
+		return err
+	}
+	if err := writeArchiveItem(bw, headerBytes); err != nil {
+		return err
+	}
+
+	if compress {
+		zw := gzip.NewWriter(bw)
+		if err := s.exportBlocks(zw, from, to); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	} else if err := s.exportBlocks(bw, from, to); err != nil {
+		return err
+	}
+	s.log.Info("Exported blockchain", "from", from, "to", to, "compressed", compress)
+	return bw.Flush()
+}
+
+func (s *syncer) exportBlocks(w io.Writer, from, to uint64) error {
+	for height := from; height <= to; height++ {
+		block := s.blockRW.GetBlockByNumber(height)
+		if block == nil {
+			return fmt.Errorf("export block %d: block not found", height)
+		}
+		bytes, err := codec.Coder().Encode(&archiveBlock{Header: block.Header(), Body: block.Body()})
+		if err != nil {
+			return err
+		}
+		if err := writeArchiveItem(w, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportChain 从归档文件中导入区块。先完整校验checksum，再将区块送入与网络同步相同的导入流程，
+// 因此需在Start之后调用。网络同步进行中时返回errBusy
+func (s *syncer) ImportChain(r io.ReadSeeker) error {
+	if s.mode == LightSync {
+		return errors.New("import chain is not supported in light sync mode")
+	}
+	header, err := s.readArchiveHeader(r)
+	if err != nil {
+		return err
+	}
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	// 第一遍只校验checksum
+	checksum := sha256.New()
+	if err := readArchiveBlocks(r, header, checksum, nil); err != nil {
+		return err
+	}
+	if types.BytesToHash(checksum.Sum(nil)) != header.Checksum {
+		return errArchiveChecksum
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	// 导入期间与网络同步共用下载队列，需独占同步流程
+	if !atomic.CompareAndSwapInt32(&s.synchronising, 0, 1) {
+		return errBusy
+	}
+	defer atomic.StoreInt32(&s.synchronising, 0)
+
+	current := s.blockRW.CurrentBlock().Height()
+	if header.To <= current {
+		s.log.Info("Block archive already imported", "to", header.To, "current", current)
+		return nil
+	}
+	s.progress.begin(archiveSource, current, header.To)
+
+	batch := make([]*models.Block, 0, archiveImportBatch)
+	err = readArchiveBlocks(r, header, nil, func(block *models.Block) error {
+		if block.Height() <= current {
+			return nil
+		}
+		batch = append(batch, block)
+		if len(batch) < archiveImportBatch {
+			return nil
+		}
+		err := s.importBatch(batch)
+		batch = batch[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := s.importBatch(batch); err != nil {
+			return err
+		}
+	}
+	s.log.Info("Imported block archive", "from", header.From, "to", header.To)
+	return nil
+}
+
+// importBatch 将区块拆分为header和body送入下载队列，并等待导入完成
+func (s *syncer) importBatch(blocks []*models.Block) error {
+	headers := make([]*models.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	// 第一个区块需连接在本地链头上，否则导入流程不会有进展
+	if head := s.blockRW.CurrentBlock(); headers[0].Height != head.Height()+1 || headers[0].ParentHash != head.Hash() {
+		return fmt.Errorf("%w: height=%d parent=%s local=%d", errUnlinkedHeader, headers[0].Height, headers[0].ParentHash.Hex(), head.Height())
+	}
+	// 归档中的区块同样需要满足最终性要求
+	final, err := s.finalHeaders(archiveSource, headers)
+	if err != nil {
+		return err
+	}
+	if len(final) < len(headers) {
+		return fmt.Errorf("%w: height=%d", errNotFinal, headers[len(final)].Height)
+	}
+	// body需在header送入队列之前校验，避免不匹配的交易进入导入流程
+	for i, block := range blocks {
+		if emptyBody(headers[i]) {
+			continue
+		}
+		if err := verifyBody(headers[i], block.Body()); err != nil {
+			return fmt.Errorf("%w: height=%d", err, headers[i].Height)
+		}
+	}
+	if err := s.deliverHeaders(archiveSource, headers); err != nil {
+		return err
+	}
+	for i, block := range blocks {
+		if !emptyBody(headers[i]) {
+			s.bodyHandle(models.P2PID(archiveSource), block.Body())
+		}
+	}
+
+	return s.waitImported(blocks[len(blocks)-1].Height())
+}
+
+func (s *syncer) readArchiveHeader(r io.Reader) (*archiveHeader, error) {
+	header, err := decodeArchiveHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if header.NetworkId != s.networkId || header.GenesisHash != s.genesisHash() {
+		return nil, fmt.Errorf("%w: network=%d genesis=%s", errArchiveChain, header.NetworkId, header.GenesisHash.Hex())
+	}
+	return header, nil
+}
+
+func decodeArchiveHeader(r io.Reader) (*archiveHeader, error) {
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != archiveMagic {
+		return nil, errInvalidArchive
+	}
+	headerBytes, err := readArchiveItem(r, maxArchiveHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	var header archiveHeader
+	if err := codec.Coder().Decode(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	if header.Version != archiveVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidArchive, header.Version)
+	}
+	if header.From > header.To {
+		return nil, fmt.Errorf("%w: from=%d to=%d", errArchiveRange, header.From, header.To)
+	}
+	return &header, nil
+}
+
+// readArchiveBlocks 顺序读取归档中的区块。checksum不为空时计算校验值，fn不为空时解码区块并回调
+func readArchiveBlocks(r io.Reader, header *archiveHeader, checksum hash.Hash, fn func(block *models.Block) error) error {
+	blocks, err := newArchiveBlocks(r, header)
+	if err != nil {
+		return err
+	}
+	defer blocks.close()
+	for {
+		height := blocks.next
+		bytes, err := blocks.item()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if checksum != nil {
+			writeArchiveItem(checksum, bytes)
+		}
+		if fn == nil {
+			continue
+		}
+		block, err := decodeArchiveBlock(bytes, height)
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveBlocks 归档中区块的顺序读取位置
+type archiveBlocks struct {
+	r    *bufio.Reader
+	zr   *gzip.Reader // 压缩时的gzip reader，可为空
+	next uint64       // 下一个区块的高度
+	to   uint64
+}
+
+// newArchiveBlocks 从r的当前位置开始读取区块，r需已读过文件头
+func newArchiveBlocks(r io.Reader, header *archiveHeader) (*archiveBlocks, error) {
+	blocks := &archiveBlocks{next: header.From, to: header.To}
+	if header.Compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		blocks.zr, r = zr, zr
+	}
+	blocks.r = bufio.NewReader(r)
+	return blocks, nil
+}
+
+// item 读取下一个区块的编码数据，已读完时返回io.EOF
+func (a *archiveBlocks) item() ([]byte, error) {
+	if a.next > a.to {
+		return nil, io.EOF
+	}
+	bytes, err := readArchiveItem(a.r, maxArchiveBlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: block %d: %v", errInvalidArchive, a.next, err)
+	}
+	a.next++
+	return bytes, nil
+}
+
+// block 读取并解码下一个区块，已读完时返回io.EOF
+func (a *archiveBlocks) block() (*models.Block, error) {
+	height := a.next
+	bytes, err := a.item()
+	if err != nil {
+		return nil, err
+	}
+	return decodeArchiveBlock(bytes, height)
+}
+
+func (a *archiveBlocks) close() {
+	if a.zr != nil {
+		a.zr.Close()
+	}
+}
+
+func decodeArchiveBlock(bytes []byte, height uint64) (*models.Block, error) {
+	var item archiveBlock
+	if err := codec.Coder().Decode(bytes, &item); err != nil {
+		return nil, fmt.Errorf("%w: block %d: %v", errInvalidArchive, height, err)
+	}
+	if item.Header == nil || item.Body == nil || item.Header.Height != height || item.Body.Height != height {
+		return nil, fmt.Errorf("%w: block %d: missing or misplaced header and body", errInvalidArchive, height)
+	}
+	// NewBlock会按交易重置交易根，需用原始header重新封装
+	return models.NewBlock(item.Header, item.Body.Txs, nil).WithSeal(item.Header), nil
+}
+
+func writeArchiveItem(w io.Writer, data []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readArchiveItem(r io.Reader, limit uint32) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > limit {
+		return nil, fmt.Errorf("item size %d exceeds limit %d", n, limit)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,113 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"testing"
+)
+
+// testArchiveChain 创建count个区块的链，奇数高度的区块带交易
+func testArchiveChain(count int) (*testChain, []*models.Block) {
+	chain := newTestChain()
+	parent := chain.GetHeaderByNumber(0)
+	blocks := make([]*models.Block, count)
+	for i := range blocks {
+		if i%2 == 0 {
+			txs := testTxs(uint64(i), uint64(i)+100)
+			blocks[i] = testTxBlock(testTxHeader(parent, txs), txs)
+		} else {
+			blocks[i] = testBlock(testHeader(parent, 0))
+		}
+		parent = blocks[i].Header()
+	}
+	chain.extendBlocks(blocks)
+	return chain, blocks
+}
+
+// exportTestArchive 导出chain中[1,to]的区块
+func exportTestArchive(t *testing.T, chain *testChain, to uint64, compress bool) []byte {
+	t.Helper()
+	s, _ := newTestSyncer(t, chain)
+	defer s.Stop()
+
+	var buf bytes.Buffer
+	if err := s.ExportChain(&buf, 1, to, compress); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%t", compress), func(t *testing.T) {
+			source, blocks := testArchiveChain(5)
+			archive := exportTestArchive(t, source, 5, compress)
+
+			chain := newTestChain()
+			s, _ := newTestSyncer(t, chain)
+			driveImports(s)
+			defer s.Stop()
+
+			if err := s.ImportChain(bytes.NewReader(archive)); err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			for _, block := range blocks {
+				imported := chain.GetBlockByNumber(block.Height())
+				if imported == nil || imported.Hash() != block.Hash() {
+					t.Fatalf("block %d not imported", block.Height())
+				}
+				if err := verifyBody(block.Header(), imported.Body()); !emptyBody(block.Header()) && err != nil {
+					t.Fatalf("block %d body: %v", block.Height(), err)
+				}
+			}
+			// 已导入的区块再次导入时直接返回
+			if err := s.ImportChain(bytes.NewReader(archive)); err != nil {
+				t.Fatalf("import again: %v", err)
+			}
+		})
+	}
+}
+
+func TestArchiveImportRejected(t *testing.T) {
+	source, _ := testArchiveChain(3)
+	archive := exportTestArchive(t, source, 3, false)
+	// 修改最后一个区块的数据，长度不变
+	corrupt := append([]byte(nil), archive...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		archive []byte
+		opts    []option
+		local   []*models.Header // 导入前本地已有的区块
+		err     error
+	}{
+		{name: "checksum", archive: corrupt, err: errArchiveChecksum},
+		{name: "network id", archive: archive, opts: []option{WithNetworkId(8)}, err: errArchiveChain},
+		{name: "genesis", archive: archive, opts: []option{WithGenesisHash(types.Hash{1})}, err: errArchiveChain},
+		{name: "not magic", archive: archive[1:], err: errInvalidArchive},
+		{name: "not linked", archive: archive, local: testHeaders(source.GetHeaderByNumber(0), 1, 1), err: errUnlinkedHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain()
+			chain.extend(tt.local)
+			s, _ := newTestSyncer(t, chain, tt.opts...)
+			driveImports(s)
+			defer s.Stop()
+
+			if err := s.ImportChain(bytes.NewReader(tt.archive)); !errors.Is(err, tt.err) {
+				t.Fatalf("import err = %v, want %v", err, tt.err)
+			}
+			if height := chain.CurrentBlock().Height(); height != uint64(len(tt.local)) {
+				t.Fatalf("local height = %d after rejected import", height)
+			}
+		})
+	}
+}
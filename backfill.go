@@ -0,0 +1,226 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	backfillBatch    = 64                     // 每批回填的区块数
+	backfillInterval = 500 * time.Millisecond // 批次之间的间隔，限制回填速率
+	backfillRetry    = 10 * time.Second       // 失败或让位于链头同步时的重试间隔
+)
+
+var (
+	errBackfillLightMode = errors.New("backfill requires full sync mode")
+	errCheckpointInvalid = errors.New("invalid checkpoint")
+	errBackfillNotStored = errors.New("inserted block not found")
+)
+
+// Checkpoint 可信检查点，从该区块开始跟随链头，历史区块在后台往创世块方向回填
+type Checkpoint struct {
+	Height uint64     `json:"height"` // 检查点高度
+	Hash   types.Hash `json:"hash"`   // 检查点区块hash
+}
+
+// BackfillProgress 历史区块回填的进度
+type BackfillProgress struct {
+	Checkpoint Checkpoint `json:"checkpoint"` // 可信检查点
+	Lowest     uint64     `json:"lowest"`     // 已校验的最低高度
+	Stored     uint64     `json:"stored"`     // 本次运行写入的区块数
+	Done       bool       `json:"done"`       // 是否已回填至创世块
+}
+
+// backfill 回填状态，next为下一批的起点(待回填的最高区块)
+type backfill struct {
+	checkpoint Checkpoint
+	anchored   bool // 检查点区块是否已在本地
+	next       types.Hash
+	nextHeight uint64
+	stored     uint64
+	done       bool
+	lock       sync.RWMutex
+}
+
+func newBackfill(checkpoint Checkpoint) *backfill {
+	return &backfill{
+		checkpoint: checkpoint,
+		next:       checkpoint.Hash,
+		nextHeight: checkpoint.Height,
+	}
+}
+
+// ready 检查点区块写入本地之前，不进行链头同步。未设置检查点时始终可同步
+func (b *backfill) ready() bool {
+	if b == nil {
+		return true
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.anchored
+}
+
+// Backfill 历史区块回填的进度，未设置检查点时返回nil
+func (s *syncer) Backfill() *BackfillProgress {
+	b := s.backfill
+	if b == nil {
+		return nil
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	lowest := b.nextHeight + 1
+	if b.done {
+		lowest = 0
+	}
+	return &BackfillProgress{
+		Checkpoint: b.checkpoint,
+		Lowest:     lowest,
+		Stored:     b.stored,
+		Done:       b.done,
+	}
+}
+
+// backfillLoop 以低于链头同步的优先级分批回填历史区块，间隔由s.clock计时
+func (s *syncer) backfillLoop() {
+	var delay time.Duration
+	for {
+		select {
+		case <-s.clock.After(delay):
+		case <-s.quitCh:
+			return
+		}
+		// 链头同步进行中或已暂停时让出
+		if s.backfill.ready() && (atomic.LoadInt32(&s.synchronising) == 1 || s.isPaused()) {
+			delay = backfillRetry
+			continue
+		}
+		done, err := s.backfillNext()
+		if err != nil {
+			s.log.Warn("Backfill failed", "err", err)
+			delay = backfillRetry
+			continue
+		}
+		if done {
+			s.log.Info("Backfill completed", "checkpoint", s.backfill.checkpoint.Height)
+			return
+		}
+		delay = backfillInterval
+	}
+}
+
+// backfillNext 从next开始反向获取一批header，校验与可信header的链接后下载body并直接写入，不重新执行。
+// InsertBlock不做校验，也不要求父区块已存在，但批内按高度升序写入，使同一批中父区块先于子区块写入；
+// 批次之间往创世块方向推进，每批的最低区块在下一批写入其父区块。写入后检查区块可读取
+func (s *syncer) backfillNext() (bool, error) {
+	b := s.backfill
+	b.lock.RLock()
+	origin, height := b.next, b.nextHeight
+	b.lock.RUnlock()
+
+	// 创世块不需要回填
+	if height == 0 {
+		b.lock.Lock()
+		b.done, b.anchored = true, true
+		b.lock.Unlock()
+		s.saveState()
+		return true, nil
+	}
+	amount := backfillBatch
+	if uint64(amount) > height {
+		amount = int(height)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 2*p2pFetchTimeout)
+	defer cancel()
+
+	headers, source, err := s.sources.headers(ctx, ext.HashOrNumber{Hash: origin, Number: height}, amount, true)
+	if err != nil {
+		return false, err
+	}
+	if len(headers) > amount {
+		headers = headers[:amount]
+	}
+	if headers[0].Hash() != origin || headers[0].Height != height {
+		return false, fmt.Errorf("%w: height=%d hash=%s", errUnlinkedHeader, height, origin.Hex())
+	}
+	for i := 1; i < len(headers); i++ {
+		if headers[i].Height+1 != headers[i-1].Height || headers[i].Hash() != headers[i-1].ParentHash {
+			return false, fmt.Errorf("%w: height=%d", errUnlinkedHeader, headers[i].Height)
+		}
+	}
+	last := headers[len(headers)-1]
+	if last.Height == 1 && last.ParentHash != s.genesisHash() {
+		return false, errGenesisMismatch
+	}
+
+	// 已在本地的区块不重复下载，空区块不需要下载body
+	var (
+		missing []*models.Header
+		full    []*models.Header
+		hashes  []types.Hash
+		heights []uint64
+	)
+	for _, header := range headers {
+		if s.blockRW.GetBlock(header.Hash(), header.Height) != nil {
+			continue
+		}
+		missing = append(missing, header)
+		if !emptyBody(header) {
+			full = append(full, header)
+			hashes = append(hashes, header.Hash())
+			heights = append(heights, header.Height)
+		}
+	}
+	if len(missing) > 0 {
+		bodies := make(map[types.Hash]*models.Body, len(hashes))
+		if len(hashes) > 0 {
+			list, err := s.sources.bodies(ctx, hashes, heights)
+			if err != nil {
+				return false, err
+			}
+			for i, body := range list {
+				if err := verifyBody(full[i], body); err != nil {
+					return false, fmt.Errorf("%w: height=%d", err, full[i].Height)
+				}
+				bodies[hashes[i]] = body
+			}
+		}
+		// headers为降序，反向遍历按升序写入
+		for i := len(missing) - 1; i >= 0; i-- {
+			header := missing[i]
+			block := NewPeerBlock(header)
+			if body := bodies[header.Hash()]; body != nil {
+				block.SetTransactions(body.Txs)
+			}
+			if err := s.blockRW.InsertBlock(block.Block, false); err != nil {
+				return false, fmt.Errorf("insert block %d: %w", header.Height, err)
+			}
+			if s.blockRW.GetBlock(header.Hash(), header.Height) == nil {
+				return false, fmt.Errorf("insert block %d: %w", header.Height, errBackfillNotStored)
+			}
+		}
+	}
+
+	b.lock.Lock()
+	b.anchored = true
+	b.stored += uint64(len(missing))
+	b.next, b.nextHeight = last.ParentHash, last.Height-1
+	b.done = b.nextHeight == 0
+	done := b.done
+	b.lock.Unlock()
+	s.saveState()
+
+	s.log.Debug("Backfilled blocks", "source", source, "count", len(missing), "lowest", last.Height)
+	return done, nil
+}
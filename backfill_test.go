@@ -0,0 +1,50 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBackfillInsertsAscendingPerBatch(t *testing.T) {
+	chain, remote := newTestChain(), newTestChain()
+	headers := testHeaders(remote.GetHeaderByNumber(0), backfillBatch+6, 0)
+	remote.extend(headers)
+	mirror := newTestMirror(t, remote)
+
+	checkpoint := headers[len(headers)-1]
+	s, _ := newTestSyncer(t, chain,
+		WithBlockSources(NewHTTPSource(mirror.URL, mirror.Client())),
+		WithCheckpoint(Checkpoint{Height: checkpoint.Height, Hash: checkpoint.Hash()}),
+	)
+	for i := 0; ; i++ {
+		if i > 2 {
+			t.Fatalf("backfill not done after %d batches", i)
+		}
+		done, err := s.backfillNext()
+		if err != nil {
+			t.Fatalf("backfill batch %d: %v", i, err)
+		}
+		if done {
+			break
+		}
+	}
+
+	// 批内升序，批次之间往创世块方向推进
+	var want []uint64
+	for height := uint64(7); height <= checkpoint.Height; height++ {
+		want = append(want, height)
+	}
+	for height := uint64(1); height < 7; height++ {
+		want = append(want, height)
+	}
+	if got := chain.insertedHeights(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("insert order = %v, want %v", got, want)
+	}
+	progress := s.Backfill()
+	if !progress.Done || progress.Lowest != 0 || progress.Stored != checkpoint.Height {
+		t.Fatalf("progress = %+v", progress)
+	}
+}
@@ -0,0 +1,113 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+)
+
+const (
+	maxBadBlocks     = 1024 // 记录的无效区块个数
+	maxPeerPenalties = 3    // 节点被惩罚该次数后断开并禁止连接
+)
+
+var errBadBlock = errors.New("known bad block")
+
+// ErrInvalidBlock 区块本身无效。BlockReadWriter.ProcessBlock返回的错误包装该错误，
+// 或实现了InvalidBlock() bool并返回true时，区块才会被记为无效区块，其余错误视为本地错误
+var ErrInvalidBlock = errors.New("invalid block")
+
+// isInvalidBlock 错误是否证明区块本身无效
+func isInvalidBlock(err error) bool {
+	if errors.Is(err, ErrInvalidBlock) {
+		return true
+	}
+	var invalid interface{ InvalidBlock() bool }
+	return errors.As(err, &invalid) && invalid.InvalidBlock()
+}
+
+// BadBlock 已知的无效区块
+type BadBlock struct {
+	Hash   types.Hash `json:"hash"`   // 区块hash
+	Height uint64     `json:"height"` // 区块高度
+	Reason string     `json:"reason"` // 被拒绝的原因
+	Source string     `json:"source"` // 区块的来源
+	Time   uint64     `json:"time"`   // 记录时间，unix秒
+}
+
+// markBadBlock 记录处理失败的区块，避免反复下载
+func (s *syncer) markBadBlock(hash types.Hash, height uint64, reason string, source string) {
+	s.badBlocks.Add(hash, &BadBlock{
+		Hash:   hash,
+		Height: height,
+		Reason: reason,
+		Source: source,
+		Time:   uint64(s.now().Unix()),
+	})
+	s.log.Warn("Marked bad block", "height", height, "hash", hash, "source", source, "reason", reason)
+	s.saveState()
+}
+
+// isBadBlock 是否为已知的无效区块
+func (s *syncer) isBadBlock(hash types.Hash) bool {
+	return s.badBlocks.Contains(hash)
+}
+
+// checkBadHeaders 校验header及其父区块是否为已知的无效区块，无效区块的后代同样记为无效
+func (s *syncer) checkBadHeaders(source string, headers []*models.Header) error {
+	for _, header := range headers {
+		hash := header.Hash()
+		if s.isBadBlock(hash) {
+			return fmt.Errorf("%w: height=%d hash=%s", errBadBlock, header.Height, hash.Hex())
+		}
+		if s.isBadBlock(header.ParentHash) {
+			s.markBadBlock(hash, header.Height, "descends from bad block "+header.ParentHash.Hex(), source)
+			return fmt.Errorf("%w: height=%d parent=%s", errBadBlock, header.Height, header.ParentHash.Hex())
+		}
+	}
+	return nil
+}
+
+// penalize 惩罚提供无效数据的节点，次数达到上限后断开并禁止连接
+func (s *syncer) penalize(peerId models.P2PID, err error) {
+	peer := s.peers.Peer(peerId)
+	if peer == nil {
+		return
+	}
+	if n := peer.penalize(); n >= maxPeerPenalties {
+		s.rejectPeer(peerId, err)
+		return
+	}
+	s.log.Debug("Penalized peer", "peer", peerId, "err", err)
+}
+
+// BadBlocks 已知的无效区块，按最近记录的顺序
+func (s *syncer) BadBlocks() []BadBlock {
+	var list []BadBlock
+	for _, key := range s.badBlocks.Keys() {
+		if value, ok := s.badBlocks.Peek(key); ok {
+			list = append(list, *value.(*BadBlock))
+		}
+	}
+	return list
+}
+
+// RemoveBadBlock 移除无效区块记录，返回记录是否存在
+func (s *syncer) RemoveBadBlock(hash types.Hash) bool {
+	if !s.badBlocks.Contains(hash) {
+		return false
+	}
+	s.badBlocks.Remove(hash)
+	s.saveState()
+	return true
+}
+
+// ClearBadBlocks 清空无效区块记录
+func (s *syncer) ClearBadBlocks() {
+	s.badBlocks.Purge()
+	s.saveState()
+}
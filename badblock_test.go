@@ -0,0 +1,118 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"strings"
+	"testing"
+)
+
+// consensusErr 实现InvalidBlock的共识校验错误
+type consensusErr struct{}
+
+func (consensusErr) Error() string      { return "invalid seal" }
+func (consensusErr) InvalidBlock() bool { return true }
+
+func TestBadBlockDescendantsRejected(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain())
+	defer s.Stop()
+
+	const id = models.P2PID("remote")
+	peer := registerTestPeer(s, id, ChainHead{})
+	bad := testHeader(s.blockRW.CurrentHeader(), 1)
+	s.markBadBlock(bad.Hash(), bad.Height, "invalid state root", "other")
+
+	headers := testHeaders(bad, 2, 1)
+	if err := s.HandleBlockHeadersMsg(id, headers); !errors.Is(err, errBadBlock) {
+		t.Fatalf("child err = %v, want %v", err, errBadBlock)
+	}
+	if !s.isBadBlock(headers[0].Hash()) {
+		t.Fatalf("child of bad block not marked")
+	}
+	var reason string
+	for _, block := range s.BadBlocks() {
+		if block.Hash == headers[0].Hash() {
+			reason = block.Reason
+		}
+	}
+	if !strings.Contains(reason, bad.Hash().Hex()) {
+		t.Fatalf("reason = %q, want ancestor %s", reason, bad.Hash().Hex())
+	}
+	// 后续只包含孙区块的批次同样被拒绝
+	if err := s.HandleBlockHeadersMsg(id, headers[1:]); !errors.Is(err, errBadBlock) {
+		t.Fatalf("grandchild err = %v, want %v", err, errBadBlock)
+	}
+	if !s.isBadBlock(headers[1].Hash()) {
+		t.Fatalf("grandchild of bad block not marked")
+	}
+	if peer.penalties != 2 {
+		t.Fatalf("penalties = %d, want 2", peer.penalties)
+	}
+}
+
+func TestBlockCompletedMarksOnlyInvalidBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		bad  bool
+	}{
+		{name: "wrapped invalid block", err: fmt.Errorf("%w: bad state root", ErrInvalidBlock), bad: true},
+		{name: "consensus error", err: fmt.Errorf("verify header: %w", consensusErr{}), bad: true},
+		{name: "local error", err: errors.New("database closed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain()
+			chain.process = func(block *models.Block) error { return tt.err }
+			s, _ := newTestSyncer(t, chain)
+			defer s.Stop()
+
+			const id = models.P2PID("remote")
+			peer := registerTestPeer(s, id, ChainHead{})
+			header := testHeader(chain.CurrentHeader(), 0)
+			block := NewPeerBlock(header)
+			block.source = string(id)
+			block.syncing = false
+			s.queues[header.Height] = block
+
+			s.blockCompleted()
+			if bad := s.isBadBlock(header.Hash()); bad != tt.bad {
+				t.Fatalf("marked bad = %t, want %t", bad, tt.bad)
+			}
+			if penalized := peer.penalties > 0; penalized != tt.bad {
+				t.Fatalf("penalized = %t, want %t", penalized, tt.bad)
+			}
+		})
+	}
+}
+
+func TestUnsolicitedBodiesDropped(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain())
+	defer s.Stop()
+
+	const id = models.P2PID("remote")
+	peer := registerTestPeer(s, id, ChainHead{})
+	header := testHeader(s.blockRW.CurrentHeader(), 0)
+	header.TxsRoot = []types.Hash{{1}}
+	header.TxsCount = 1
+	s.queues[header.Height] = NewPeerBlock(header)
+
+	// 队列中没有的高度直接丢弃
+	s.HandleBlockBodiesMsg(id, []*models.Body{{Height: header.Height + 1}})
+	if peer.penalties != 0 {
+		t.Fatalf("penalized for body without queued header")
+	}
+	// 与header的交易根不符的body被丢弃，并惩罚提供的节点
+	s.HandleBlockBodiesMsg(id, []*models.Body{{Height: header.Height}})
+	if !s.queues[header.Height].syncing {
+		t.Fatalf("mismatched body completed the queued block")
+	}
+	if peer.penalties != 1 {
+		t.Fatalf("penalties = %d, want 1", peer.penalties)
+	}
+}
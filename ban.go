@@ -0,0 +1,62 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-protocol/models"
+	"sync"
+	"time"
+)
+
+const banDuration = 30 * time.Minute // 不兼容节点的禁止时间
+
+// peerBans 被禁止连接的节点及解禁时间
+type peerBans struct {
+	until map[models.P2PID]time.Time
+	now   func() time.Time // 当前时间，回放时由模拟时钟决定
+	lock  sync.Mutex
+}
+
+func newPeerBans(now func() time.Time) *peerBans {
+	return &peerBans{until: make(map[models.P2PID]time.Time), now: now}
+}
+
+func (b *peerBans) ban(id models.P2PID, until time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.until[id] = until
+}
+
+// banned 是否在禁止期内，已过期的会被移除
+func (b *peerBans) banned(id models.P2PID) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	until, ok := b.until[id]
+	if !ok {
+		return false
+	}
+	if b.now().After(until) {
+		delete(b.until, id)
+		return false
+	}
+	return true
+}
+
+// list 未过期的禁止记录
+func (b *peerBans) list() map[models.P2PID]time.Time {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+	list := make(map[models.P2PID]time.Time, len(b.until))
+	for id, until := range b.until {
+		if now.After(until) {
+			delete(b.until, id)
+			continue
+		}
+		list[id] = until
+	}
+	return list
+}
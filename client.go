@@ -4,7 +4,7 @@
 package syncer
 
 import (
-	"github.com/chain5j/chain5j-pkg/codec"
+	"fmt"
 	"github.com/chain5j/chain5j-pkg/types"
 	"github.com/chain5j/chain5j-protocol/models"
 	"github.com/chain5j/chain5j-protocol/models/ext"
@@ -20,16 +20,7 @@ func (s *syncer) RequestOneHeader(peerId models.P2PID, hash types.Hash) error {
 		// Skip:    uint64(0),
 		Reverse: false,
 	}
-	bytes, err := codec.Coder().Encode(req)
-	if err != nil {
-		return err
-	}
-
-	return s.p2p.Send(peerId, &models.P2PMessage{
-		Type: GetBlockHeadersMsg,
-		Peer: "",
-		Data: bytes,
-	})
+	return s.sendMsg(peerId, GetBlockHeadersMsg, req)
 }
 
 func (s *syncer) RequestHeadersByHash(peerId models.P2PID, origin types.Hash, amount int, skip int, reverse bool) error {
@@ -42,16 +33,7 @@ func (s *syncer) RequestHeadersByHash(peerId models.P2PID, origin types.Hash, am
 		// Skip:    uint64(skip),
 		Reverse: reverse,
 	}
-	bytes, err := codec.Coder().Encode(req)
-	if err != nil {
-		return err
-	}
-
-	return s.p2p.Send(peerId, &models.P2PMessage{
-		Type: GetBlockHeadersMsg,
-		Peer: "",
-		Data: bytes,
-	})
+	return s.sendMsg(peerId, GetBlockHeadersMsg, req)
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
@@ -66,31 +48,14 @@ func (s *syncer) RequestHeadersByNumber(peerId models.P2PID, origin uint64, amou
 		// Skip:    uint64(skip),
 		Reverse: reverse,
 	}
-	bytes, err := codec.Coder().Encode(req)
-	if err != nil {
-		return err
-	}
-
-	return s.p2p.Send(peerId, &models.P2PMessage{
-		Type: GetBlockHeadersMsg,
-		Peer: "",
-		Data: bytes,
-	})
+	return s.sendMsg(peerId, GetBlockHeadersMsg, req)
 }
 
 // ====================body==============
 // hashes: blockHash
 func (s *syncer) RequestBlockBodies(peerId models.P2PID, hashes []types.Hash) error {
 	s.log.Debug("Fetching batch of block bodies", "count", len(hashes))
-	bytes, err := codec.Coder().Encode(hashes)
-	if err != nil {
-		return err
-	}
-	return s.p2p.Send(peerId, &models.P2PMessage{
-		Type: GetBlockBodiesMsg,
-		Peer: "",
-		Data: bytes,
-	})
+	return s.sendMsg(peerId, GetBlockBodiesMsg, hashes)
 }
 
 // 处理区块Header
@@ -102,38 +67,152 @@ func (s *syncer) HandleBlockHeadersMsg(peerId models.P2PID, headers []*models.He
 		s.syncDrop.Stop()
 		s.syncDrop = nil
 	}
+	s.markHeaders(peerId, headers)
+	// 已知的无效区块或其后代直接丢弃，并惩罚提供的节点
+	if err := s.checkBadHeaders(string(peerId), headers); err != nil {
+		s.log.Warn("drop bad headers", "peer", peerId, "err", err)
+		s.penalize(peerId, err)
+		return err
+	}
+	// 同步流程发出的请求
+	if s.fetcher.deliverHeaders(peerId, headers) {
+		return nil
+	}
+	// 不是同步来源的节点主动推送的header不导入
+	if !s.isSyncSource(peerId) {
+		return nil
+	}
+	// 暂停时不处理主动推送的header，超过同步目标的直接丢弃
+	if s.isPaused() {
+		return nil
+	}
+	if n := len(headers); n > 0 {
+		limit := s.capTarget(headers[n-1].Height)
+		for n > 0 && headers[n-1].Height > limit {
+			n--
+		}
+		if headers = headers[:n]; n == 0 {
+			return nil
+		}
+	}
+	headers, err := s.finalHeaders(string(peerId), headers)
+	if err != nil {
+		s.log.Warn("verify finality err", "peer", peerId, "err", err)
+		return err
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	// 轻节点只校验并写入header，不下载body
+	if s.mode == LightSync {
+		if err := s.insertHeaders(string(peerId), headers); err != nil {
+			s.log.Warn("insert headers err", "peer", peerId, "err", err)
+			return err
+		}
+		return nil
+	}
 
+	if err := s.deliverHeaders(string(peerId), headers); err != nil {
+		s.log.Warn("deliver headers err", "peer", peerId, "err", err)
+		return err
+	}
+	// 空区块已在队列中完成，只请求有交易的区块
 	var hashes []types.Hash
 	for _, header := range headers {
-		hashes = append(hashes, header.Hash())
-		s.headerHandle(peerId, header)
+		if !emptyBody(header) {
+			hashes = append(hashes, header.Hash())
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
 	}
 	return s.RequestBlockBodies(peerId, hashes)
 }
 
+// deliverHeaders 校验header的连续性后放入下载队列，网络同步与archive导入共用。
+// 调用前需已完成最终性校验，校验通过的header用于更新来源节点的链头
+func (s *syncer) deliverHeaders(source string, headers []*models.Header) error {
+	if err := s.checkBadHeaders(source, headers); err != nil {
+		return err
+	}
+	for i := 1; i < len(headers); i++ {
+		if headers[i].Height != headers[i-1].Height+1 || headers[i].ParentHash != headers[i-1].Hash() {
+			return fmt.Errorf("%w: height=%d", errUnlinkedHeader, headers[i].Height)
+		}
+	}
+	s.updateHeadFromHeaders(models.P2PID(source), headers)
+	var completed bool
+	s.queueLock.Lock()
+	for _, header := range headers {
+		if s.headerHandle(source, header) {
+			completed = true
+		}
+	}
+	s.queueLock.Unlock()
+
+	// 有空区块时直接通知导入流程
+	if completed {
+		select {
+		case s.blockCompletedCh <- struct{}{}:
+		case <-s.quitCh:
+		}
+	}
+	return nil
+}
+
 // 处理Bodies
 func (s *syncer) HandleBlockBodiesMsg(peerId models.P2PID, request []*models.Body) {
 	if request == nil || len(request) == 0 {
 		return
 	}
+	s.markBodies(peerId, request)
+	// 同步流程或按需请求发出的请求
+	if s.fetcher.deliverBodies(peerId, request) {
+		return
+	}
+	if !s.isSyncSource(peerId) {
+		return
+	}
+	if s.mode == LightSync {
+		return
+	}
 	for _, body := range request {
-		s.bodyHandle(body)
+		if body == nil {
+			continue
+		}
+		s.bodyHandle(peerId, body)
 	}
 }
 
-func (s *syncer) bodyHandle(body *models.Body) {
+// bodyHandle 将body放入下载队列，只接受队列中等待body且与header相符的body
+func (s *syncer) bodyHandle(peerId models.P2PID, body *models.Body) {
+	s.queueLock.Lock()
 	block := s.queues[body.Height]
-	if block == nil {
-		s.log.Error("bodyHandle is error", "blockHeight", body.Height)
+	if block == nil || !block.syncing {
+		s.queueLock.Unlock()
+		s.log.Debug("drop unsolicited body", "peer", peerId, "blockHeight", body.Height)
+		return
+	}
+	if err := verifyBody(block.Header(), body); err != nil {
+		s.queueLock.Unlock()
+		s.log.Warn("drop invalid body", "peer", peerId, "blockHeight", body.Height, "err", err)
+		s.penalize(peerId, err)
 		return
 	}
 	block.SetTransactions(body.Txs)
 	block.syncing = false
+	s.queueLock.Unlock()
+
 	// 发送chan
-	s.blockCompletedCh <- struct{}{}
+	select {
+	case s.blockCompletedCh <- struct{}{}:
+	case <-s.quitCh:
+	}
 }
 
-func (s *syncer) headerHandle(peerId models.P2PID, header *models.Header) {
+// headerHandle 将header放入下载队列，空区块不需要body，直接标记为完成并返回true
+func (s *syncer) headerHandle(source string, header *models.Header) bool {
 	remoteHeight := header.Height
 	remoteHash := header.Hash()
 	tempBlock := s.queues[remoteHeight]
@@ -144,5 +223,10 @@ func (s *syncer) headerHandle(peerId models.P2PID, header *models.Header) {
 		}
 	}
 	peerBlock := NewPeerBlock(header)
+	peerBlock.source = source
+	if emptyBody(header) {
+		peerBlock.syncing = false
+	}
 	s.queues[remoteHeight] = peerBlock
+	return !peerBlock.syncing
 }
@@ -0,0 +1,108 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/golang/snappy"
+)
+
+// Compression 同步数据的压缩算法，在状态交换时协商，双方都支持时才启用
+type Compression uint8
+
+const (
+	CompressionNone   Compression = iota // 不压缩
+	CompressionSnappy                    // snappy压缩
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+const (
+	minCompressSize        = 256              // 小于该长度的数据不压缩
+	maxDecompressedPayload = 16 * 1024 * 1024 // 解压后数据的最大长度，防止解压炸弹
+)
+
+var (
+	errUnknownCompression = errors.New("unknown payload compression")
+	errPayloadTooLarge    = errors.New("decompressed payload too large")
+)
+
+// compressedMsgs 需要压缩的批量同步消息
+var compressedMsgs = map[uint64]bool{
+	BlockHeadersMsg: true,
+	BlockBodiesMsg:  true,
+}
+
+// capabilities 压缩算法对应的能力
+func (c Compression) capabilities() uint64 {
+	if c == CompressionSnappy {
+		return CapSnappy
+	}
+	return 0
+}
+
+// negotiateCompression 双方都支持的压缩算法
+func (s *syncer) negotiateCompression(remote uint64) Compression {
+	if s.compression == CompressionSnappy && remote&CapSnappy != 0 {
+		return CompressionSnappy
+	}
+	return CompressionNone
+}
+
+// encodePayload 已协商压缩的节点，批量消息的数据以1字节的压缩算法开头
+func (s *syncer) encodePayload(peerId models.P2PID, code uint64, data []byte) []byte {
+	if !compressedMsgs[code] {
+		return data
+	}
+	peer := s.peers.Peer(peerId)
+	if peer == nil || peer.Compression() == CompressionNone {
+		return data
+	}
+	if len(data) >= minCompressSize {
+		if compressed := snappy.Encode(nil, data); len(compressed) < len(data) {
+			return append([]byte{byte(CompressionSnappy)}, compressed...)
+		}
+	}
+	return append([]byte{byte(CompressionNone)}, data...)
+}
+
+// decodePayload 解压批量消息，先校验声明的解压长度再分配内存
+func (s *syncer) decodePayload(peerId models.P2PID, code uint64, data []byte) ([]byte, error) {
+	if !compressedMsgs[code] {
+		return data, nil
+	}
+	peer := s.peers.Peer(peerId)
+	if peer == nil || peer.Compression() == CompressionNone {
+		return data, nil
+	}
+	if len(data) == 0 {
+		return nil, errUnknownCompression
+	}
+	switch Compression(data[0]) {
+	case CompressionNone:
+		return data[1:], nil
+	case CompressionSnappy:
+		size, err := snappy.DecodedLen(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size > maxDecompressedPayload {
+			return nil, fmt.Errorf("%w: %d bytes", errPayloadTooLarge, size)
+		}
+		return snappy.Decode(nil, data[1:])
+	default:
+		return nil, fmt.Errorf("%w: %d", errUnknownCompression, data[0])
+	}
+}
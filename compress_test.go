@@ -0,0 +1,92 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/golang/snappy"
+	"math/big"
+	"testing"
+)
+
+func TestStatusNegotiatesCompression(t *testing.T) {
+	tests := []struct {
+		name   string
+		local  Compression
+		remote uint64 // 对方声明的能力
+		want   Compression
+	}{
+		{name: "both snappy", local: CompressionSnappy, remote: defaultCapabilities | CapSnappy, want: CompressionSnappy},
+		{name: "remote without snappy", local: CompressionSnappy, remote: defaultCapabilities, want: CompressionNone},
+		{name: "local without snappy", local: CompressionNone, remote: defaultCapabilities | CapSnappy, want: CompressionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSyncer(t, newTestChain(), WithCompression(tt.local))
+			defer s.Stop()
+
+			const id = models.P2PID("remote")
+			status := s.localStatus()
+			status.Weight = new(big.Int)
+			status.Capabilities = tt.remote
+			s.handleStatus(&statusMsg{peer: id, status: status})
+
+			peer := s.peers.Peer(id)
+			if peer == nil {
+				t.Fatalf("peer not registered")
+			}
+			if compression := peer.Compression(); compression != tt.want {
+				t.Fatalf("compression = %s, want %s", compression, tt.want)
+			}
+			// 未协商压缩时批量消息按原始数据发送
+			data := bytes.Repeat([]byte{1}, 2*minCompressSize)
+			payload := s.encodePayload(id, BlockHeadersMsg, data)
+			if plain := bytes.Equal(payload, data); plain != (tt.want == CompressionNone) {
+				t.Fatalf("plain payload = %t, want %t", plain, tt.want == CompressionNone)
+			}
+			decoded, err := s.decodePayload(id, BlockHeadersMsg, payload)
+			if err != nil || !bytes.Equal(decoded, data) {
+				t.Fatalf("decode payload err = %v, round trip = %t", err, bytes.Equal(decoded, data))
+			}
+		})
+	}
+}
+
+func TestDecodePayloadLimits(t *testing.T) {
+	// snappy数据以解压长度的varint开头，声明超出上限的长度而不携带实际数据
+	header := make([]byte, binary.MaxVarintLen64)
+	bomb := append([]byte{byte(CompressionSnappy)}, header[:binary.PutUvarint(header, maxDecompressedPayload+1)]...)
+	bomb = append(bomb, make([]byte, 16)...)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		err     error
+	}{
+		{name: "snappy", payload: append([]byte{byte(CompressionSnappy)}, snappy.Encode(nil, []byte("headers"))...)},
+		{name: "none", payload: append([]byte{byte(CompressionNone)}, "headers"...)},
+		{name: "decompression bomb", payload: bomb, err: errPayloadTooLarge},
+		{name: "unknown compression", payload: append([]byte{9}, "headers"...), err: errUnknownCompression},
+		{name: "empty", payload: nil, err: errUnknownCompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSyncer(t, newTestChain(), WithCompression(CompressionSnappy))
+			defer s.Stop()
+
+			const id = models.P2PID("remote")
+			registerTestPeer(s, id, ChainHead{}).SetCompression(CompressionSnappy)
+			data, err := s.decodePayload(id, BlockBodiesMsg, tt.payload)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("decode payload err = %v, want %v", err, tt.err)
+			}
+			if err == nil && string(data) != "headers" {
+				t.Fatalf("decoded payload = %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"sync"
+)
+
+// 同步状态
+const (
+	StateIdle          = "idle"           // 空闲
+	StateSyncing       = "syncing"        // 同步中
+	StatePaused        = "paused"         // 已暂停
+	StateTargetReached = "target_reached" // 已到达指定的目标
+	StateTargetInvalid = "target_invalid" // 到达目标高度，但hash与指定的不一致
+)
+
+var (
+	errTargetBelowHead = errors.New("sync target is below local head")
+	errUnknownTarget   = errors.New("unknown sync target")
+)
+
+// syncControl 同步的暂停及目标控制
+type syncControl struct {
+	paused   bool
+	resumeCh chan struct{} // 暂停时创建，恢复时关闭
+
+	targetHeight uint64     // 目标高度，0表示没有目标
+	targetHash   types.Hash // 目标hash，为空时只按高度
+	lock         sync.RWMutex
+}
+
+// Pause 暂停同步，正在进行中的批次完成后停止
+func (s *syncer) Pause() {
+	s.control.lock.Lock()
+	defer s.control.lock.Unlock()
+
+	if s.control.paused {
+		return
+	}
+	s.control.paused = true
+	s.control.resumeCh = make(chan struct{})
+	s.log.Info("Synchronisation paused")
+}
+
+// Resume 恢复同步
+func (s *syncer) Resume() {
+	s.control.lock.Lock()
+	if !s.control.paused {
+		s.control.lock.Unlock()
+		return
+	}
+	s.control.paused = false
+	close(s.control.resumeCh)
+	s.control.lock.Unlock()
+
+	s.log.Info("Synchronisation resumed")
+	go s.syncWithSources()
+}
+
+// SyncTo 同步到指定高度后停止
+func (s *syncer) SyncTo(height uint64) error {
+	if height < s.localHeight() {
+		return fmt.Errorf("%w: target=%d local=%d", errTargetBelowHead, height, s.localHeight())
+	}
+	s.setTarget(height, types.Hash{})
+	go s.syncWithSources()
+	return nil
+}
+
+// SyncToHash 同步到指定区块后停止，区块的高度从本地或区块来源中获取
+func (s *syncer) SyncToHash(ctx context.Context, hash types.Hash) error {
+	if header := s.localHeader(hash); header != nil {
+		if header.Height < s.localHeight() {
+			return fmt.Errorf("%w: target=%d local=%d", errTargetBelowHead, header.Height, s.localHeight())
+		}
+		s.setTarget(header.Height, hash)
+		return nil
+	}
+	headers, err := s.sources.Headers(ctx, ext.HashOrNumber{Hash: hash}, 1, false)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnknownTarget, err)
+	}
+	if len(headers) == 0 || headers[0].Hash() != hash {
+		return errUnknownTarget
+	}
+	if headers[0].Height < s.localHeight() {
+		return fmt.Errorf("%w: target=%d local=%d", errTargetBelowHead, headers[0].Height, s.localHeight())
+	}
+	s.setTarget(headers[0].Height, hash)
+	go s.syncWithSources()
+	return nil
+}
+
+// ClearTarget 清除同步目标，恢复跟随最优节点
+func (s *syncer) ClearTarget() {
+	s.setTarget(0, types.Hash{})
+	s.log.Info("Sync target cleared")
+	go s.syncWithSources()
+}
+
+func (s *syncer) setTarget(height uint64, hash types.Hash) {
+	s.control.lock.Lock()
+	s.control.targetHeight, s.control.targetHash = height, hash
+	s.control.lock.Unlock()
+	s.saveState()
+
+	if height > 0 {
+		s.log.Info("Sync target set", "height", height, "hash", hash)
+	}
+}
+
+// capTarget 有同步目标时，不能超过目标高度
+func (s *syncer) capTarget(height uint64) uint64 {
+	s.control.lock.RLock()
+	defer s.control.lock.RUnlock()
+
+	if s.control.targetHeight > 0 && height > s.control.targetHeight {
+		return s.control.targetHeight
+	}
+	return height
+}
+
+// capHead 有同步目标时，达到目标高度的链头按目标同步。指定了目标hash时以目标区块为链头，
+// 同步到目标所在的分叉
+func (s *syncer) capHead(head ChainHead) ChainHead {
+	s.control.lock.RLock()
+	height, hash := s.control.targetHeight, s.control.targetHash
+	s.control.lock.RUnlock()
+
+	if height == 0 || head.Height < height || head.Height == height && hash == (types.Hash{}) {
+		return head
+	}
+	return ChainHead{Hash: hash, Height: height}
+}
+
+// isTargetBlock head是否为指定hash的同步目标
+func (s *syncer) isTargetBlock(head ChainHead) bool {
+	s.control.lock.RLock()
+	defer s.control.lock.RUnlock()
+	return s.control.targetHash != (types.Hash{}) && head.Hash == s.control.targetHash && head.Height == s.control.targetHeight
+}
+
+// waitResumed 暂停时阻塞直至恢复
+func (s *syncer) waitResumed() error {
+	s.control.lock.RLock()
+	paused, resumeCh := s.control.paused, s.control.resumeCh
+	s.control.lock.RUnlock()
+
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumeCh:
+		return nil
+	case <-s.quitCh:
+		return errStopped
+	}
+}
+
+// isPaused 是否已暂停
+func (s *syncer) isPaused() bool {
+	s.control.lock.RLock()
+	defer s.control.lock.RUnlock()
+	return s.control.paused
+}
+
+// syncState 当前的同步状态
+func (s *syncer) syncState(current, highest uint64) (state string, target uint64, targetHash types.Hash) {
+	s.control.lock.RLock()
+	paused := s.control.paused
+	target, targetHash = s.control.targetHeight, s.control.targetHash
+	s.control.lock.RUnlock()
+
+	switch {
+	case paused:
+		state = StatePaused
+	case target > 0 && current >= target:
+		state = StateTargetReached
+		if targetHash != (types.Hash{}) {
+			if header := s.localHeaderByNumber(target); header == nil || header.Hash() != targetHash {
+				state = StateTargetInvalid
+			}
+		}
+	case current < highest:
+		state = StateSyncing
+	default:
+		state = StateIdle
+	}
+	return state, target, targetHash
+}
@@ -0,0 +1,175 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newControlTest 本地链有local个区块，远程镜像的规范链有remote个区块，
+// 另有从高度2分叉的fork个区块，返回的syncer已处理导入流程
+func newControlTest(t *testing.T, local, remote, fork int) (s *syncer, chain *testChain, canonical, forked []*models.Header) {
+	t.Helper()
+	mirrorChain := newTestChain()
+	genesis := mirrorChain.GetHeaderByNumber(0)
+	canonical = testHeaders(genesis, remote, 0)
+	forked = testHeaders(canonical[1], fork, 1)
+	mirrorChain.extend(forked)
+	mirrorChain.extend(canonical)
+	mirror := newTestMirror(t, mirrorChain)
+
+	chain = newTestChain()
+	chain.extend(canonical[:local])
+	s, _ = newTestSyncer(t, chain, WithBlockSources(NewHTTPSource(mirror.URL, mirror.Client())))
+	driveImports(s)
+	t.Cleanup(func() { s.Stop() })
+	return s, chain, canonical, forked
+}
+
+// waitHead 等待本地链头到达head
+func waitHead(t *testing.T, chain *testChain, head *models.Header) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); chain.CurrentBlock().Hash() != head.Hash(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("local head = %d, want %d", chain.CurrentBlock().Height(), head.Height)
+		}
+	}
+}
+
+// waitIdle 等待进行中的同步结束，之后发起的同步不会因errBusy被忽略
+func waitIdle(t *testing.T, s *syncer) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&s.synchronising) == 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("synchronise still running")
+		}
+	}
+}
+
+func TestSyncTargetValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		set    func(s *syncer, canonical, forked []*models.Header) error
+		local  int
+		err    error
+		target uint64
+	}{
+		{name: "height below head", set: func(s *syncer, canonical, forked []*models.Header) error { return s.SyncTo(2) }, err: errTargetBelowHead},
+		{name: "height", set: func(s *syncer, canonical, forked []*models.Header) error { return s.SyncTo(5) }, target: 5},
+		{
+			name: "local hash below head",
+			set: func(s *syncer, canonical, forked []*models.Header) error {
+				return s.SyncToHash(context.Background(), canonical[1].Hash())
+			},
+			err: errTargetBelowHead,
+		},
+		{
+			name: "local head hash",
+			set: func(s *syncer, canonical, forked []*models.Header) error {
+				return s.SyncToHash(context.Background(), canonical[2].Hash())
+			},
+			target: 3,
+		},
+		{
+			name: "remote hash",
+			set: func(s *syncer, canonical, forked []*models.Header) error {
+				return s.SyncToHash(context.Background(), canonical[5].Hash())
+			},
+			target: 6,
+		},
+		{
+			name: "remote fork at head height",
+			set: func(s *syncer, canonical, forked []*models.Header) error {
+				return s.SyncToHash(context.Background(), forked[0].Hash())
+			},
+			target: 3,
+		},
+		{
+			name:  "remote fork below head",
+			local: 4,
+			set: func(s *syncer, canonical, forked []*models.Header) error {
+				return s.SyncToHash(context.Background(), forked[0].Hash())
+			},
+			err: errTargetBelowHead,
+		},
+		{
+			name: "unknown hash",
+			set: func(s *syncer, canonical, forked []*models.Header) error {
+				return s.SyncToHash(context.Background(), types.Hash{1})
+			},
+			err: errUnknownTarget,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.local == 0 {
+				tt.local = 3
+			}
+			s, _, canonical, forked := newControlTest(t, tt.local, 8, 2)
+			s.Pause() // 只检查目标，不同步
+
+			if err := tt.set(s, canonical, forked); !errors.Is(err, tt.err) {
+				t.Fatalf("set target err = %v, want %v", err, tt.err)
+			}
+			if target := s.Progress().Target; target != tt.target {
+				t.Fatalf("target = %d, want %d", target, tt.target)
+			}
+		})
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	s, chain, canonical, _ := newControlTest(t, 0, 6, 0)
+
+	s.Pause()
+	s.syncWithSources()
+	if height := chain.CurrentBlock().Height(); height != 0 {
+		t.Fatalf("synced to %d while paused", height)
+	}
+	if state := s.Progress().State; state != StatePaused {
+		t.Fatalf("state = %s, want %s", state, StatePaused)
+	}
+	s.Resume()
+	waitHead(t, chain, canonical[5])
+}
+
+func TestSyncToAndClearTarget(t *testing.T) {
+	s, chain, canonical, _ := newControlTest(t, 0, 8, 0)
+
+	if err := s.SyncTo(4); err != nil {
+		t.Fatalf("sync to: %v", err)
+	}
+	waitHead(t, chain, canonical[3])
+	waitIdle(t, s)
+	if state := s.Progress().State; state != StateTargetReached {
+		t.Fatalf("state = %s, want %s", state, StateTargetReached)
+	}
+	// 到达目标后不再继续同步
+	s.syncWithSources()
+	if height := chain.CurrentBlock().Height(); height != 4 {
+		t.Fatalf("synced past target to %d", height)
+	}
+	s.ClearTarget()
+	waitHead(t, chain, canonical[7])
+}
+
+func TestSyncToHashFollowsTargetFork(t *testing.T) {
+	// 远程规范链比目标分叉更高，按高度同步会停在规范链上
+	s, chain, _, forked := newControlTest(t, 1, 8, 4)
+	target := forked[2]
+
+	if err := s.SyncToHash(context.Background(), target.Hash()); err != nil {
+		t.Fatalf("sync to hash: %v", err)
+	}
+	waitHead(t, chain, target)
+	if state := s.Progress().State; state != StateTargetReached {
+		t.Fatalf("state = %s, want %s", state, StateTargetReached)
+	}
+}
@@ -3,14 +3,344 @@
 // @author: xwc1125
 package syncer
 
-func (s *syncer) findAncestor(peer *peer, remoteHeight uint64) {
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	importTimeout      = 60 * time.Second       // 导入流程无进展的超时时间
+	importPollInterval = 100 * time.Millisecond // 检查导入进度的间隔
+)
+
+var (
+	errBusy          = errors.New("already synchronising")
+	errImportFailed  = errors.New("block import failed")
+	errImportStalled = errors.New("block import stalled")
+)
+
+// synchronise 从区块来源中分批下载header及body直至target优于本地链头，同一时间只有一个同步流程。
+// target的权重更高但高度不超过本地时，从公共祖先开始导入分叉
+func (s *syncer) synchronise(origin string, target ChainHead) error {
+	if !atomic.CompareAndSwapInt32(&s.synchronising, 0, 1) {
+		return errBusy
+	}
+	defer atomic.StoreInt32(&s.synchronising, 0)
+
+	// 暂停或检查点区块尚未写入时不同步
+	if s.isPaused() || !s.backfill.ready() {
+		return nil
+	}
+	target = s.capHead(target)
+	s.progress.begin(origin, s.localHeight(), target.Height)
+	for {
+		if err := s.waitResumed(); err != nil {
+			return err
+		}
+		// 同步过程中目标可能被修改
+		target = s.capHead(target)
+		local := s.localHead()
+		toBlock := s.isTargetBlock(target)
+		if toBlock {
+			// 指定区块的目标按hash判断是否到达，本地同一高度为其他分叉时导入目标所在的分叉
+			if local.Height >= target.Height {
+				if header := s.localHeaderByNumber(target.Height); header != nil && header.Hash() == target.Hash {
+					return nil
+				}
+				return s.syncFork(target.Hash, target.Height)
+			}
+		} else if s.compare(target, local) <= 0 {
+			return nil
+		}
+		localHeight := local.Height
+		if localHeight >= target.Height {
+			if target.Hash == (types.Hash{}) {
+				return nil
+			}
+			return s.syncFork(target.Hash, target.Height)
+		}
+		from, count, _, _ := calculateRequestSpan(target.Height, localHeight)
+		// 流水线下载时一批数据可拆分为多个请求
+		if remain := target.Height - localHeight; remain > uint64(count) {
+			count = MaxHeaderFetch * syncBatchRequests
+			if remain < uint64(count) {
+				count = int(remain)
+			}
+		}
+		var (
+			headers []*models.Header
+			source  string
+			err     error
+		)
+		if remain := target.Height - localHeight; toBlock && remain <= uint64(MaxHeaderFetch) {
+			headers, source, err = s.targetHeaders(target, int(remain))
+		} else {
+			headers, source, err = s.sources.headers(s.ctx, ext.HashOrNumber{Number: uint64(from)}, count, false)
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.importHeaders(source, headers); err != nil {
+			return err
+		}
+	}
+}
+
+// targetHeaders 最后一批从目标区块往回获取count个header，按高度升序返回，
+// 保证导入的是目标所在的分叉。与本地链不相连时由importHeaders导入分叉
+func (s *syncer) targetHeaders(target ChainHead, count int) ([]*models.Header, string, error) {
+	headers, source, err := s.sources.headers(s.ctx, ext.HashOrNumber{Hash: target.Hash, Number: target.Height}, count, true)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(headers) != count || headers[0].Hash() != target.Hash {
+		return nil, "", fmt.Errorf("%w: hash=%s source=%s", errUnknownTarget, target.Hash.Hex(), source)
+	}
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	return headers, source, nil
+}
+
+// importHeaders 轻节点直接写入header，全节点还需下载body后送入导入流程
+func (s *syncer) importHeaders(source string, headers []*models.Header) error {
+	// 与本地链头不相连时，先导入公共祖先之后的分叉
+	if local := s.localHead(); len(headers) > 0 && headers[0].Height == local.Height+1 && headers[0].ParentHash != local.Hash {
+		if err := s.syncFork(headers[0].ParentHash, local.Height); err != nil {
+			return err
+		}
+	}
+	headers, err := s.finalHeaders(source, headers)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return errNotFinal
+	}
+	if s.mode == LightSync {
+		return s.insertHeaders(source, headers)
+	}
+	if err := s.deliverHeaders(source, headers); err != nil {
+		return err
+	}
+	// 空区块在放入队列时已完成，只下载有交易的区块
+	var (
+		full    []*models.Header
+		hashes  []types.Hash
+		heights []uint64
+	)
+	for _, header := range headers {
+		if !emptyBody(header) {
+			full = append(full, header)
+			hashes = append(hashes, header.Hash())
+			heights = append(heights, header.Height)
+		}
+	}
+	if len(hashes) > 0 {
+		bodies, err := s.sources.bodies(s.ctx, hashes, heights)
+		if err != nil {
+			return err
+		}
+		for i, body := range bodies {
+			if err := verifyBody(full[i], body); err != nil {
+				return fmt.Errorf("%w: height=%d", err, full[i].Height)
+			}
+		}
+		for _, body := range bodies {
+			s.bodyHandle(models.P2PID(source), body)
+		}
+	}
+	return s.waitImported(headers[len(headers)-1].Height)
+}
+
+// waitImported 等待导入流程处理到height，处理失败或长时间无进展时返回错误
+func (s *syncer) waitImported(height uint64) error {
+	s.progress.lock.RLock()
+	failed := s.progress.failed
+	s.progress.lock.RUnlock()
+
+	current := s.blockRW.CurrentBlock().Height()
+	deadline := s.clock.Now().Add(importTimeout)
+	for current < height {
+		select {
+		case <-s.clock.After(importPollInterval):
+		case <-s.quitCh:
+			return errStopped
+		}
+		s.progress.lock.RLock()
+		failedNow := s.progress.failed
+		s.progress.lock.RUnlock()
+		if failedNow > failed {
+			return fmt.Errorf("%w at height %d", errImportFailed, current+1)
+		}
+		if h := s.blockRW.CurrentBlock().Height(); h > current {
+			current, deadline = h, s.clock.Now().Add(importTimeout)
+		}
+		if s.clock.Now() > deadline {
+			return fmt.Errorf("%w at height %d", errImportStalled, current+1)
+		}
+	}
+	return nil
+}
+
+// heightsOf 根据下载队列及本地链查找区块hash对应的高度，未知的为0
+func (s *syncer) heightsOf(hashes []types.Hash) []uint64 {
+	heights := make([]uint64, len(hashes))
+	index := make(map[types.Hash]int, len(hashes))
+	for i, hash := range hashes {
+		index[hash] = i
+	}
+	s.queueLock.Lock()
+	for height, block := range s.queues {
+		if i, ok := index[block.Hash()]; ok {
+			heights[i] = height
+		}
+	}
+	s.queueLock.Unlock()
+
+	for i, hash := range hashes {
+		if heights[i] != 0 {
+			continue
+		}
+		if header := s.localHeader(hash); header != nil {
+			heights[i] = header.Height
+		}
+	}
+	return heights
+}
+
+// syncWithSources 以所有来源中的最高高度为目标进行同步
+func (s *syncer) syncWithSources() {
+	ctx, cancel := context.WithTimeout(s.ctx, p2pFetchTimeout)
+	height, err := s.sources.Height(ctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	head := ChainHead{Height: height}
+	// 最优节点的链头带有hash及权重，可发现更重的分叉
+	if peer := s.peers.BestPeer(); peer != nil {
+		if best := peer.ChainHead(); best.Height >= height {
+			head = best
+		}
+	}
+	if s.compare(head, s.localHead()) <= 0 {
+		return
+	}
+	if err := s.synchronise(s.sources.Name(), head); err != nil && err != errBusy {
+		s.log.Warn("synchronise err", "target", head.Height, "err", err)
+	}
+}
+
+// syncFork 从hash往创世块方向获取header直至与本地链相连，再从公共祖先开始导入分叉
+func (s *syncer) syncFork(hash types.Hash, height uint64) error {
 	var (
-		localHeight uint64
+		fork   []*models.Header
+		source string
+		origin = hash
 	)
-	localHeight = s.blockRW.CurrentBlock().Height()
+	for {
+		if len(fork) > maxReorgDepth {
+			return fmt.Errorf("%w: depth>%d", errReorgTooDeep, maxReorgDepth)
+		}
+		headers, name, err := s.sources.headers(s.ctx, ext.HashOrNumber{Hash: origin, Number: height}, MaxHeaderFetch, true)
+		if err != nil {
+			return err
+		}
+		source = name
+		linked := false
+		for i, header := range headers {
+			if i == 0 && (header.Hash() != origin || header.Height != height) ||
+				i > 0 && (header.Hash() != headers[i-1].ParentHash || header.Height+1 != headers[i-1].Height) {
+				return fmt.Errorf("%w: height=%d", errUnlinkedHeader, header.Height)
+			}
+			if s.localHeader(header.Hash()) != nil {
+				linked = true
+				break
+			}
+			if header.Height == 0 {
+				return errGenesisMismatch
+			}
+			fork = append(fork, header)
+		}
+		if linked {
+			break
+		}
+		last := fork[len(fork)-1]
+		origin, height = last.ParentHash, last.Height-1
+	}
+	if len(fork) == 0 {
+		return nil
+	}
+	for i, j := 0, len(fork)-1; i < j; i, j = i+1, j-1 {
+		fork[i], fork[j] = fork[j], fork[i]
+	}
+	s.log.Info("Importing fork", "source", source, "ancestor", fork[0].Height-1, "count", len(fork))
+	return s.importFork(source, fork)
+}
 
-	from, count, skip, _ := calculateRequestSpan(remoteHeight, localHeight)
-	go s.RequestHeadersByNumber(peer.P2PID, uint64(from), count, skip, false)
+// importFork 导入与本地链相连的分叉。分叉的区块高度不超过本地链头，不经过按高度排列的下载队列，直接依次处理
+func (s *syncer) importFork(source string, headers []*models.Header) error {
+	headers, err := s.finalHeaders(source, headers)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return errNotFinal
+	}
+	if s.mode == LightSync {
+		return s.insertHeaders(source, headers)
+	}
+	if err := s.checkBadHeaders(source, headers); err != nil {
+		return err
+	}
+	var (
+		full    []*models.Header
+		hashes  []types.Hash
+		heights []uint64
+	)
+	for _, header := range headers {
+		if !emptyBody(header) {
+			full = append(full, header)
+			hashes = append(hashes, header.Hash())
+			heights = append(heights, header.Height)
+		}
+	}
+	bodies := make(map[types.Hash]*models.Body, len(hashes))
+	if len(hashes) > 0 {
+		list, err := s.sources.bodies(s.ctx, hashes, heights)
+		if err != nil {
+			return err
+		}
+		for i, body := range list {
+			if err := verifyBody(full[i], body); err != nil {
+				return fmt.Errorf("%w: height=%d", err, full[i].Height)
+			}
+			bodies[hashes[i]] = body
+		}
+	}
+	for _, header := range headers {
+		block := NewPeerBlock(header)
+		if body := bodies[header.Hash()]; body != nil {
+			block.SetTransactions(body.Txs)
+		}
+		if err := s.blockRW.ProcessBlock(block.Block, false); err != nil {
+			if isInvalidBlock(err) {
+				s.markBadBlock(header.Hash(), header.Height, err.Error(), source)
+				s.penalize(models.P2PID(source), err)
+			}
+			s.progress.addFailed()
+			return fmt.Errorf("%w at height %d: %v", errImportFailed, header.Height, err)
+		}
+		s.progress.addImported()
+	}
+	return nil
 }
 
 // common ancestor.
@@ -0,0 +1,121 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-protocol/models"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// forkWeigher 分叉编号越大权重越高，同一分叉内按高度递增
+type forkWeigher struct{}
+
+func (forkWeigher) Weight(header *models.Header) *big.Int {
+	weight := header.Height
+	if len(header.Extra) > 0 {
+		weight += uint64(header.Extra[0]) * 100
+	}
+	return new(big.Int).SetUint64(weight + 1)
+}
+
+func TestSynchroniseHeavierFork(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    int
+		remote   int
+		fork     byte
+		switched bool
+	}{
+		{name: "heavier and lower", local: 5, remote: 3, fork: 2, switched: true},
+		{name: "heavier at same height", local: 3, remote: 3, fork: 2, switched: true},
+		{name: "lighter and lower", local: 5, remote: 3, fork: 0},
+		{name: "heavier and higher", local: 3, remote: 6, fork: 2, switched: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, remote := newTestChain(), newTestChain()
+			genesis := chain.GetHeaderByNumber(0)
+			chain.extend(testHeaders(genesis, tt.local, 1))
+			fork := testHeaders(genesis, tt.remote, tt.fork)
+			remote.extend(fork)
+			mirror := newTestMirror(t, remote)
+
+			s, _ := newTestSyncer(t, chain,
+				WithBlockSources(NewHTTPSource(mirror.URL, mirror.Client())),
+				WithChainWeigher(forkWeigher{}),
+			)
+			head := fork[len(fork)-1]
+			target := ChainHead{Hash: head.Hash(), Height: head.Height, Weight: forkWeigher{}.Weight(head)}
+			before := s.localHead()
+
+			// 更高的分叉经由下载队列导入，由导入流程处理，模拟时钟推进以检查导入进度
+			if tt.remote > tt.local {
+				driveImports(s)
+				defer s.Stop()
+			}
+			if err := s.synchronise("test", target); err != nil {
+				t.Fatalf("synchronise: %v", err)
+			}
+			current := chain.CurrentBlock().Hash()
+			if switched := current == head.Hash(); switched != tt.switched {
+				t.Fatalf("switched = %t, want %t", switched, tt.switched)
+			}
+			if !tt.switched && current != before.Hash {
+				t.Fatalf("local head changed without a heavier target")
+			}
+		})
+	}
+}
+
+func TestEmptyBlocksSkipBodyRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		fetch  func(s *syncer, id models.P2PID, headers []*models.Header) error
+		txs    bool // 批次中是否有带交易的区块
+		bodies bool
+	}{
+		{name: "synchronise", fetch: func(s *syncer, id models.P2PID, headers []*models.Header) error {
+			return s.importHeaders(string(id), headers)
+		}},
+		{name: "headers msg", fetch: func(s *syncer, id models.P2PID, headers []*models.Header) error {
+			return s.HandleBlockHeadersMsg(id, headers)
+		}},
+		{name: "headers msg with txs", txs: true, bodies: true, fetch: func(s *syncer, id models.P2PID, headers []*models.Header) error {
+			return s.HandleBlockHeadersMsg(id, headers)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain()
+			s, _ := newTestSyncer(t, chain)
+			driveImports(s)
+			defer s.Stop()
+
+			headers := testHeaders(chain.GetHeaderByNumber(0), 4, 0)
+			if tt.txs {
+				headers = append(headers, testTxHeader(headers[3], testTxs(1)))
+			}
+			// 不启动发送协程，请求留在发送队列中
+			const id = models.P2PID("remote")
+			remote := newPeer(s.p2p, id)
+			remote.SetChainHead(ChainHead{Hash: headers[len(headers)-1].Hash(), Height: uint64(len(headers))})
+			s.peers.Register(remote, nil)
+
+			if err := tt.fetch(s, id, headers); err != nil {
+				t.Fatalf("fetch: %v", err)
+			}
+			if requested := len(requestedPeers([]*peer{remote}, GetBlockBodiesMsg)) > 0; requested != tt.bodies {
+				t.Fatalf("requested bodies = %t, want %t", requested, tt.bodies)
+			}
+			// 空区块无需body即可导入
+			for deadline := time.Now().Add(5 * time.Second); chain.CurrentBlock().Height() < 4; time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("empty blocks imported to %d, want 4", chain.CurrentBlock().Height())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"sync"
+)
+
+// fetchRequest 已发出、等待响应的请求
+type fetchRequest struct {
+	peer models.P2PID
+
+	origin  ext.HashOrNumber // header请求的起点
+	hashes  []types.Hash     // body请求的区块hash
+	heights []uint64         // body请求对应的区块高度，0表示未知
+	headers chan []*models.Header
+	bodies  chan []*models.Body
+	failed  chan error // 对方明确表示无法提供
+}
+
+// fetcher 将远程节点的响应与本地发出的请求进行匹配。
+// 同一节点的响应按请求顺序返回，因此按节点维护先进先出的请求队列
+type fetcher struct {
+	headerReqs map[models.P2PID][]*fetchRequest
+	bodyReqs   map[models.P2PID][]*fetchRequest
+	lock       sync.Mutex
+}
+
+func newFetcher() *fetcher {
+	return &fetcher{
+		headerReqs: make(map[models.P2PID][]*fetchRequest),
+		bodyReqs:   make(map[models.P2PID][]*fetchRequest),
+	}
+}
+
+func (f *fetcher) addHeaders(peerId models.P2PID, origin ext.HashOrNumber) *fetchRequest {
+	req := &fetchRequest{
+		peer:    peerId,
+		origin:  origin,
+		headers: make(chan []*models.Header, 1),
+		failed:  make(chan error, 1),
+	}
+	f.lock.Lock()
+	f.headerReqs[peerId] = append(f.headerReqs[peerId], req)
+	f.lock.Unlock()
+	return req
+}
+
+func (f *fetcher) addBodies(peerId models.P2PID, hashes []types.Hash, heights []uint64) *fetchRequest {
+	req := &fetchRequest{
+		peer:    peerId,
+		hashes:  hashes,
+		heights: heights,
+		bodies:  make(chan []*models.Body, 1),
+		failed:  make(chan error, 1),
+	}
+	f.lock.Lock()
+	f.bodyReqs[peerId] = append(f.bodyReqs[peerId], req)
+	f.lock.Unlock()
+	return req
+}
+
+// remove 请求完成或超时后移除
+func (f *fetcher) remove(req *fetchRequest) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	reqs := f.headerReqs
+	if req.bodies != nil {
+		reqs = f.bodyReqs
+	}
+	list := reqs[req.peer]
+	for i, r := range list {
+		if r == req {
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(reqs, req.peer)
+	} else {
+		reqs[req.peer] = list
+	}
+}
+
+// deliverHeaders 匹配header响应，返回是否有等待的请求
+func (f *fetcher) deliverHeaders(peerId models.P2PID, headers []*models.Header) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for i, req := range f.headerReqs[peerId] {
+		if len(headers) > 0 && !matchOrigin(req.origin, headers[0]) {
+			continue
+		}
+		f.headerReqs[peerId] = append(f.headerReqs[peerId][:i:i], f.headerReqs[peerId][i+1:]...)
+		req.headers <- headers
+		return true
+	}
+	return false
+}
+
+// deliverBodies 匹配body响应，返回是否有等待的请求
+func (f *fetcher) deliverBodies(peerId models.P2PID, bodies []*models.Body) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	reqs := f.bodyReqs[peerId]
+	for i, req := range reqs {
+		if !matchBodies(req.heights, bodies) {
+			continue
+		}
+		f.bodyReqs[peerId] = append(reqs[:i:i], reqs[i+1:]...)
+		req.bodies <- bodies
+		return true
+	}
+	return false
+}
+
+// fail 对方无法提供时结束对应的请求，返回是否有等待的请求
+func (f *fetcher) fail(peerId models.P2PID, data *notAvailableData, err error) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	reqs := f.headerReqs
+	if data.Code == GetBlockBodiesMsg {
+		reqs = f.bodyReqs
+	}
+	for i, req := range reqs[peerId] {
+		if req.bodies != nil {
+			if len(req.hashes) == 0 || req.hashes[0] != data.Hash {
+				continue
+			}
+		} else if req.origin != data.Origin {
+			continue
+		}
+		reqs[peerId] = append(reqs[peerId][:i:i], reqs[peerId][i+1:]...)
+		req.failed <- err
+		return true
+	}
+	return false
+}
+
+func matchOrigin(origin ext.HashOrNumber, header *models.Header) bool {
+	if header == nil {
+		return false
+	}
+	if origin.Hash != (types.Hash{}) {
+		return header.Hash() == origin.Hash
+	}
+	return header.Height == origin.Number
+}
+
+// matchBodies body的个数不能超过请求的个数，且已知高度的需一致
+func matchBodies(heights []uint64, bodies []*models.Body) bool {
+	if len(bodies) > len(heights) {
+		return false
+	}
+	for i, body := range bodies {
+		if body != nil && heights[i] != 0 && body.Height != heights[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+)
+
+const maxValidatorSets = 256 // 缓存的验证者集合个数
+
+// FinalityMode 同步时对区块最终性的要求
+type FinalityMode int
+
+const (
+	FinalityOff      FinalityMode = iota // 不校验提交证书
+	FinalityVerify                       // 校验已有的提交证书，没有证书的header仍导入
+	FinalityRequired                     // 只导入提交证书校验通过的区块
+)
+
+func (mode FinalityMode) String() string {
+	switch mode {
+	case FinalityOff:
+		return "off"
+	case FinalityVerify:
+		return "verify"
+	case FinalityRequired:
+		return "required"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(mode))
+	}
+}
+
+var (
+	// ErrNoCommit header尚未有可用的提交证书，FinalityVerifier在证书缺失时返回
+	ErrNoCommit = errors.New("no commit certificate")
+
+	errNoFinalityVerifier = errors.New("finality mode requires a finality verifier")
+	errInvalidCommit      = errors.New("invalid commit certificate")
+	errUnknownValidators  = errors.New("unknown validator set")
+	errNotFinal           = errors.New("no final headers")
+)
+
+// InvalidHeaderError FinalityVerifier确认header中hash覆盖的内容无效时返回，如验证者集合的hash与证书不符。
+// 只有该错误会将Header记为无效区块，其余校验失败只惩罚来源并丢弃该批header
+type InvalidHeaderError struct {
+	Header *models.Header // 无效的header
+	Err    error
+}
+
+func (e *InvalidHeaderError) Error() string {
+	return fmt.Sprintf("invalid header %d: %v", e.Header.Height, e.Err)
+}
+
+func (e *InvalidHeaderError) Unwrap() error { return e.Err }
+
+// ValidatorSet 一个epoch的验证者集合
+type ValidatorSet struct {
+	Epoch      uint64          `json:"epoch"`      // epoch编号
+	Validators []types.Address `json:"validators"` // 验证者地址
+}
+
+// FinalityVerifier 校验BFT共识的提交证书，由共识模块实现
+type FinalityVerifier interface {
+	// Validators 本地已导入的parent之后的区块使用的验证者集合
+	Validators(parent *models.Header) (*ValidatorSet, error)
+	// VerifyCommit 使用validators校验header的提交证书。证书可由header自身或其子区块next携带，
+	// next可为空；证书缺失时返回ErrNoCommit
+	VerifyCommit(header *models.Header, next *models.Header, validators *ValidatorSet) error
+	// NextValidators header为epoch的最后一个区块时返回之后生效的验证者集合，否则返回nil
+	NextValidators(header *models.Header, validators *ValidatorSet) (*ValidatorSet, error)
+}
+
+// validatorSets 按区块hash记录其子区块使用的验证者集合，长时间同步时跨epoch切换
+type validatorSets struct {
+	verifier FinalityVerifier
+	sets     *lruCache // hash==>*ValidatorSet
+}
+
+func newValidatorSets(verifier FinalityVerifier) *validatorSets {
+	return &validatorSets{
+		verifier: verifier,
+		sets:     newLRUCache(maxValidatorSets),
+	}
+}
+
+// validatorsAfter parent之后的区块使用的验证者集合，先查已校验的header，再查本地链
+func (s *syncer) validatorsAfter(parentHash types.Hash) (*ValidatorSet, error) {
+	if set, ok := s.validators.sets.Get(parentHash); ok {
+		return set.(*ValidatorSet), nil
+	}
+	parent := s.localHeader(parentHash)
+	if parent == nil {
+		return nil, fmt.Errorf("%w: parent=%s", errUnknownValidators, parentHash.Hex())
+	}
+	set, err := s.validators.verifier.Validators(parent)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnknownValidators, err)
+	}
+	s.validators.sets.Add(parentHash, set)
+	return set, nil
+}
+
+// finalHeaders 按最终性要求校验相连的header。证书无效时惩罚来源并丢弃整批header，
+// 只有header自身被证明无效时才记为无效区块；FinalityRequired时只返回证书校验通过的部分
+func (s *syncer) finalHeaders(source string, headers []*models.Header) ([]*models.Header, error) {
+	if s.finality == FinalityOff || len(headers) == 0 {
+		return headers, nil
+	}
+	set, err := s.validatorsAfter(headers[0].ParentHash)
+	if err != nil {
+		return nil, err
+	}
+	for i, header := range headers {
+		var next *models.Header
+		if i+1 < len(headers) {
+			next = headers[i+1]
+		}
+		err := s.validators.verifier.VerifyCommit(header, next, set)
+		switch {
+		case errors.Is(err, ErrNoCommit):
+			if s.finality == FinalityRequired {
+				if i > 0 {
+					s.validators.sets.Add(headers[i-1].Hash(), set)
+				}
+				s.log.Debug("Truncated headers without commit", "source", source, "height", header.Height, "final", i)
+				return headers[:i], nil
+			}
+		case err != nil:
+			// 证书由next携带时，失败归于next
+			culprit := header
+			if next != nil && errors.Is(s.validators.verifier.VerifyCommit(header, nil, set), ErrNoCommit) {
+				culprit = next
+			}
+			s.rejectHeaders(source, culprit, err)
+			return nil, fmt.Errorf("%w: height=%d: %v", errInvalidCommit, culprit.Height, err)
+		}
+		// epoch切换后的区块使用新的验证者集合
+		nextSet, err := s.validators.verifier.NextValidators(header, set)
+		if err != nil {
+			s.rejectHeaders(source, header, err)
+			return nil, fmt.Errorf("%w: height=%d: %v", errUnknownValidators, header.Height, err)
+		}
+		if nextSet != nil {
+			s.log.Info("Validator set changed", "height", header.Height, "epoch", nextSet.Epoch, "validators", len(nextSet.Validators))
+			set = nextSet
+			s.validators.sets.Add(header.Hash(), set)
+		}
+	}
+	s.validators.sets.Add(headers[len(headers)-1].Hash(), set)
+	return headers, nil
+}
+
+// rejectHeaders 最终性校验失败时惩罚来源，header被证明无效时记为无效区块
+func (s *syncer) rejectHeaders(source string, culprit *models.Header, err error) {
+	var invalid *InvalidHeaderError
+	if errors.As(err, &invalid) {
+		if invalid.Header != nil {
+			culprit = invalid.Header
+		}
+		s.markBadBlock(culprit.Hash(), culprit.Height, err.Error(), source)
+	}
+	s.log.Warn("Rejected headers with invalid finality", "source", source, "height", culprit.Height, "err", err)
+	s.penalize(models.P2PID(source), err)
+}
@@ -0,0 +1,88 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-protocol/models"
+	"strings"
+	"testing"
+)
+
+func TestFinalHeadersRejectsInvalidCommits(t *testing.T) {
+	errSignature := errors.New("bad signature")
+	tests := []struct {
+		name     string
+		verifier func(headers []*models.Header) *testVerifier
+		err      error
+		culprit  uint64 // 错误归属的高度
+		bad      uint64 // 记为无效区块的高度，0为不记录
+	}{
+		{
+			name: "invalid signature",
+			verifier: func(headers []*models.Header) *testVerifier {
+				return &testVerifier{commits: map[uint64]error{2: errSignature}}
+			},
+			err: errInvalidCommit, culprit: 2,
+		},
+		{
+			name: "invalid signature from next",
+			verifier: func(headers []*models.Header) *testVerifier {
+				return &testVerifier{commits: map[uint64]error{2: errSignature}, fromNext: true}
+			},
+			err: errInvalidCommit, culprit: 3,
+		},
+		{
+			name: "header proven invalid",
+			verifier: func(headers []*models.Header) *testVerifier {
+				return &testVerifier{commits: map[uint64]error{2: &InvalidHeaderError{Header: headers[1], Err: errSignature}}}
+			},
+			err: errInvalidCommit, culprit: 2, bad: 2,
+		},
+		{
+			name: "next header proven invalid",
+			verifier: func(headers []*models.Header) *testVerifier {
+				return &testVerifier{commits: map[uint64]error{2: &InvalidHeaderError{Header: headers[2], Err: errSignature}}, fromNext: true}
+			},
+			err: errInvalidCommit, culprit: 3, bad: 3,
+		},
+		{
+			name: "invalid validator set",
+			verifier: func(headers []*models.Header) *testVerifier {
+				return &testVerifier{nexts: map[uint64]error{2: errSignature}}
+			},
+			err: errUnknownValidators, culprit: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain()
+			headers := testHeaders(chain.CurrentHeader(), 4, 0)
+			s, _ := newTestSyncer(t, chain, WithFinality(FinalityVerify, tt.verifier(headers)))
+			defer s.Stop()
+
+			const id = models.P2PID("remote")
+			peer := registerTestPeer(s, id, ChainHead{})
+			final, err := s.finalHeaders(string(id), headers)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if !strings.Contains(err.Error(), fmt.Sprintf("height=%d:", tt.culprit)) {
+				t.Fatalf("err = %v, want culprit height %d", err, tt.culprit)
+			}
+			if len(final) != 0 {
+				t.Fatalf("kept %d headers of a rejected batch", len(final))
+			}
+			if peer.penalties != 1 {
+				t.Fatalf("penalties = %d, want 1", peer.penalties)
+			}
+			for _, header := range headers {
+				if bad := s.isBadBlock(header.Hash()); bad != (header.Height == tt.bad) {
+					t.Fatalf("height %d marked bad = %t", header.Height, bad)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"math/big"
+	"sync/atomic"
+	"testing"
+)
+
+// 入站消息的fuzz入口，覆盖从解码到处理的完整路径。
+// 消息经dispatchMsg处理，不经过handleMsg的panic恢复，处理中的panic直接由fuzz报告；
+// 状态消息由handleStatus处理，其panic会被恢复并计入统计，同样视为失败。
+// 例如: go test -run '^$' -fuzz FuzzBlockHeadersMsg
+
+const fuzzPeer = models.P2PID("fuzz")
+
+// newFuzzSyncer 使用模拟依赖创建syncer，并注册发送方节点
+func newFuzzSyncer(t *testing.T) *syncer {
+	chain := newTestChain()
+	chain.extend(testHeaders(chain.GetHeaderByNumber(0), 2, 0))
+	s, _ := newTestSyncer(t, chain)
+	registerTestPeer(s, fuzzPeer, ChainHead{})
+
+	// 代替syncBlocks处理区块完成的通知
+	go func() {
+		for {
+			select {
+			case <-s.blockCompletedCh:
+				s.blockCompleted()
+			case <-s.quitCh:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// fuzzSeed 编码合法的消息作为种子
+func fuzzSeed(f *testing.F, v interface{}) {
+	data, err := codec.Coder().Encode(v)
+	if err != nil {
+		f.Fatalf("encode seed %T: %v", v, err)
+	}
+	f.Add(data)
+}
+
+// fuzzMsg 以fuzzPeer的身份处理消息，解码或处理失败的输入直接忽略
+func fuzzMsg(f *testing.F, code uint64, seeds ...interface{}) {
+	for _, seed := range seeds {
+		fuzzSeed(f, seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := newFuzzSyncer(t)
+		defer s.Stop()
+
+		s.dispatchMsg(&models.P2PMessage{Type: uint(code), Peer: fuzzPeer, Data: data})
+	})
+}
+
+func FuzzStatusMsg(f *testing.F) {
+	local, _ := newTestSyncer(f, newTestChain())
+	status := local.localStatus()
+	status.Weight = new(big.Int)
+	fuzzSeed(f, status)
+	local.Stop()
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := newFuzzSyncer(t)
+		defer s.Stop()
+
+		status, err := s.decodeMsg(s.router.handlers[StatusMsg], &models.P2PMessage{Type: StatusMsg, Peer: fuzzPeer, Data: data})
+		if err != nil {
+			return
+		}
+		s.handleStatus(&statusMsg{peer: fuzzPeer, status: status.(*statusData)})
+		if panics := atomic.LoadUint64(&s.router.stats[StatusMsg].Panics); panics > 0 {
+			t.Fatalf("handleStatus panicked")
+		}
+	})
+}
+
+func FuzzNewBlockHashesMsg(f *testing.F) {
+	header := testHeader(newTestChain().GetHeaderByNumber(0), 1)
+	fuzzMsg(f, NewBlockHashesMsg, []blockAnnounce{{Hash: header.Hash(), Number: header.Height}})
+}
+
+func FuzzTxMsg(f *testing.F) {
+	fuzzMsg(f, TxMsg, models.Transactions{})
+}
+
+func FuzzGetBlockHeadersMsg(f *testing.F) {
+	fuzzMsg(f, GetBlockHeadersMsg,
+		&ext.GetBlockHeadersData{Origin: ext.HashOrNumber{Number: 0}, Amount: 3},
+		&ext.GetBlockHeadersData{Origin: ext.HashOrNumber{Number: 2}, Amount: 2, Reverse: true},
+	)
+}
+
+func FuzzBlockHeadersMsg(f *testing.F) {
+	chain := newTestChain()
+	fuzzMsg(f, BlockHeadersMsg, testHeaders(chain.GetHeaderByNumber(0), 3, 1))
+}
+
+func FuzzGetBlockBodiesMsg(f *testing.F) {
+	fuzzMsg(f, GetBlockBodiesMsg, []types.Hash{newTestChain().GetHeaderByNumber(0).Hash()})
+}
+
+func FuzzBlockBodiesMsg(f *testing.F) {
+	fuzzMsg(f, BlockBodiesMsg, []*models.Body{{Height: 1}})
+}
+
+func FuzzNotAvailableMsg(f *testing.F) {
+	fuzzMsg(f, NotAvailableMsg, &notAvailableData{Code: GetBlockHeadersMsg, Origin: ext.HashOrNumber{Number: 5}, HistoryFrom: 3})
+}
@@ -6,6 +6,7 @@ require (
 	github.com/chain5j/chain5j-pkg v1.0.2
     github.com/chain5j/chain5j-protocol v0.0.0-20220101110409-5fb9e85ebaa3
 	github.com/chain5j/logger v0.0.2
+	github.com/golang/snappy v0.0.1
 )
 
 require (
@@ -13,7 +14,6 @@ require (
 	github.com/aristanetworks/goarista v0.0.0-20200812190859-4cb0e71f3c0e // indirect
 	github.com/btcsuite/btcd v0.21.0-beta // indirect
 	github.com/cespare/xxhash/v2 v2.1.1 // indirect
-	github.com/golang/snappy v0.0.1 // indirect
 	github.com/json-iterator/go v1.1.9 // indirect
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible // indirect
 	github.com/lestrrat-go/strftime v1.0.5 // indirect
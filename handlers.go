@@ -0,0 +1,158 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+)
+
+const (
+	maxAnnounces = 256  // 单个区块通知消息的最大条数
+	maxTxsPerMsg = 4096 // 单个交易消息的最大交易数
+)
+
+var (
+	errNilHeader = errors.New("nil header")
+	errNilTx     = errors.New("nil transaction")
+)
+
+// syncHandlers 区块同步协议的消息处理
+func syncHandlers() []*msgHandler {
+	return []*msgHandler{
+		{
+			code:       StatusMsg,
+			name:       "status",
+			maxSize:    4 * 1024,
+			rate:       rateUnlimited,
+			fixedCodec: true,
+			newMsg:     func() interface{} { return new(statusData) },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				// 状态交换在syncBlocks中处理。等待处理完成后再分发后续消息，
+				// 使对方之后的消息按协商的编码及压缩算法解码
+				done := make(chan struct{})
+				select {
+				case s.statusCh <- &statusMsg{peer: peerId, status: msg.(*statusData), done: done}:
+				case <-s.quitCh:
+					return errStopped
+				}
+				select {
+				case <-done:
+					return nil
+				case <-s.quitCh:
+					return errStopped
+				}
+			},
+		},
+		{
+			code:    GetBlockHeadersMsg,
+			name:    "getBlockHeaders",
+			maxSize: 1024,
+			rate:    rateRequest,
+			newMsg:  func() interface{} { return new(ext.GetBlockHeadersData) },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				s.SendBlockHeaders(peerId, *msg.(*ext.GetBlockHeadersData))
+				return nil
+			},
+		},
+		{
+			code:     GetBlockBodiesMsg,
+			name:     "getBlockBodies",
+			maxSize:  64 * 1024,
+			maxItems: MaxBodyFetch,
+			rate:     rateRequest,
+			newMsg:   func() interface{} { return new([]types.Hash) },
+			items:    func(msg interface{}) (int, error) { return len(*msg.(*[]types.Hash)), nil },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				s.SendBlockBodies(peerId, *msg.(*[]types.Hash))
+				return nil
+			},
+		},
+		{
+			code:       BlockHeadersMsg,
+			name:       "blockHeaders",
+			maxSize:    maxDecompressedPayload,
+			maxItems:   MaxHeaderFetch,
+			rate:       rateUnlimited,
+			compressed: true,
+			newMsg:     func() interface{} { return new([]*models.Header) },
+			items: func(msg interface{}) (int, error) {
+				headers := *msg.(*[]*models.Header)
+				for _, header := range headers {
+					if header == nil {
+						return 0, errNilHeader
+					}
+				}
+				return len(headers), nil
+			},
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				return s.HandleBlockHeadersMsg(peerId, *msg.(*[]*models.Header))
+			},
+		},
+		{
+			code:       BlockBodiesMsg,
+			name:       "blockBodies",
+			maxSize:    maxDecompressedPayload,
+			maxItems:   MaxBodyFetch,
+			rate:       rateUnlimited,
+			compressed: true,
+			newMsg:     func() interface{} { return new([]*models.Body) },
+			items:      func(msg interface{}) (int, error) { return len(*msg.(*[]*models.Body)), nil },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				s.HandleBlockBodiesMsg(peerId, *msg.(*[]*models.Body))
+				return nil
+			},
+		},
+		{
+			code:     NewBlockHashesMsg,
+			name:     "newBlockHashes",
+			maxSize:  64 * 1024,
+			maxItems: maxAnnounces,
+			rate:     rateGossip,
+			newMsg:   func() interface{} { return new([]blockAnnounce) },
+			items:    func(msg interface{}) (int, error) { return len(*msg.(*[]blockAnnounce)), nil },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				s.handleAnnounces(peerId, *msg.(*[]blockAnnounce))
+				return nil
+			},
+		},
+		{
+			code:     TxMsg,
+			name:     "transactions",
+			maxSize:  8 * 1024 * 1024,
+			maxItems: maxTxsPerMsg,
+			rate:     rateGossip,
+			newMsg:   func() interface{} { return new(models.Transactions) },
+			items: func(msg interface{}) (int, error) {
+				n := 0
+				for _, list := range *msg.(*models.Transactions) {
+					for _, tx := range list {
+						if tx == nil {
+							return 0, errNilTx
+						}
+					}
+					n += len(list)
+				}
+				return n, nil
+			},
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				s.handleTxs(peerId, *msg.(*models.Transactions))
+				return nil
+			},
+		},
+		{
+			code:    NotAvailableMsg,
+			name:    "notAvailable",
+			maxSize: 1024,
+			rate:    rateUnlimited,
+			newMsg:  func() interface{} { return new(notAvailableData) },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				s.handleNotAvailable(peerId, msg.(*notAvailableData))
+				return nil
+			},
+		},
+	}
+}
@@ -0,0 +1,348 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/chain5j/chain5j-pkg/crypto/signature"
+	"github.com/chain5j/chain5j-pkg/event"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/eventtype"
+	"github.com/chain5j/chain5j-protocol/protocol"
+	"github.com/chain5j/logger"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger 测试时没有节点初始化logger，使用不输出的logger
+type testLogger struct{}
+
+func init() {
+	logger.RegisterLog(testLogger{})
+	models.RegisterTransaction(new(testTx))
+}
+
+func (l testLogger) Name() string                                        { return "test" }
+func (l testLogger) New(module string, ctx ...interface{}) logger.Logger { return l }
+func (l testLogger) Trace(msg string, ctx ...interface{})                {}
+func (l testLogger) Debug(msg string, ctx ...interface{})                {}
+func (l testLogger) Info(msg string, ctx ...interface{})                 {}
+func (l testLogger) Warn(msg string, ctx ...interface{})                 {}
+func (l testLogger) Error(msg string, ctx ...interface{})                {}
+func (l testLogger) Crit(msg string, ctx ...interface{})                 {}
+func (l testLogger) Printf(format string, v ...interface{})              {}
+func (l testLogger) Print(v ...interface{})                              {}
+func (l testLogger) Println(v ...interface{})                            {}
+func (l testLogger) Fatal(v ...interface{})                              {}
+func (l testLogger) Fatalf(format string, v ...interface{})              {}
+func (l testLogger) Fatalln(v ...interface{})                            {}
+func (l testLogger) Panic(v ...interface{})                              {}
+func (l testLogger) Panicf(format string, v ...interface{})              {}
+func (l testLogger) Panicln(v ...interface{})                            {}
+
+// testHeader 创建parent的子区块header，fork用于区分同一高度的不同分叉
+func testHeader(parent *models.Header, fork byte) *models.Header {
+	header := &models.Header{
+		Extra:     []byte{fork},
+		Signature: &signature.SignResult{Name: "test"},
+	}
+	if parent != nil {
+		header.Height = parent.Height + 1
+		header.ParentHash = parent.Hash()
+		header.Timestamp = parent.Timestamp + 1
+	}
+	return header
+}
+
+// testHeaders 从parent开始创建count个相连的header
+func testHeaders(parent *models.Header, count int, fork byte) []*models.Header {
+	headers := make([]*models.Header, count)
+	for i := range headers {
+		parent = testHeader(parent, fork)
+		headers[i] = parent
+	}
+	return headers
+}
+
+// testBlock 使用header封装区块，保持header中的交易根不变
+func testBlock(header *models.Header) *models.Block {
+	return models.NewBlock(header, nil, nil).WithSeal(header)
+}
+
+// testTx 测试用交易，hash由nonce决定
+type testTx struct {
+	Nonce uint64
+}
+
+func (tx *testTx) TxType() types.TxType { return "test" }
+func (tx *testTx) ChainId() string      { return "test" }
+func (tx *testTx) Hash() types.Hash {
+	var hash types.Hash
+	binary.BigEndian.PutUint64(hash[:], tx.Nonce+1)
+	return hash
+}
+func (tx *testTx) Less(other models.Transaction) bool { return tx.Nonce < other.(*testTx).Nonce }
+func (tx *testTx) Size() types.StorageSize            { return 8 }
+func (tx *testTx) Serialize() ([]byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, tx.Nonce)
+	return data, nil
+}
+func (tx *testTx) Deserialize(data []byte) error {
+	if len(data) != 8 {
+		return errors.New("invalid test tx")
+	}
+	tx.Nonce = binary.BigEndian.Uint64(data)
+	return nil
+}
+
+// testTxs 按nonce创建一组交易
+func testTxs(nonces ...uint64) models.Transactions {
+	list := make(models.TransactionSortedList, len(nonces))
+	for i, nonce := range nonces {
+		list[i] = &testTx{Nonce: nonce}
+	}
+	return models.Transactions{list}
+}
+
+// testTxHeader 创建parent的子区块header，交易根与txs一致
+func testTxHeader(parent *models.Header, txs models.Transactions) *models.Header {
+	header := testHeader(parent, 0)
+	header.TxsRoot = txs.TxsRoot()
+	header.TxsCount = uint64(txs.AllLen())
+	return header
+}
+
+// testTxBlock 使用header及交易封装区块，header中的交易根需与txs一致
+func testTxBlock(header *models.Header, txs models.Transactions) *models.Block {
+	return models.NewBlock(header, txs, nil).WithSeal(header)
+}
+
+// testChain 内存中的区块链，按高度记录规范链
+type testChain struct {
+	blocks    map[types.Hash]*models.Block
+	canonical map[uint64]types.Hash
+	head      *models.Block
+	inserted  []uint64 // InsertBlock写入的高度，按调用顺序
+	process   func(block *models.Block) error
+	feed      event.Feed
+	lock      sync.RWMutex
+}
+
+func newTestChain() *testChain {
+	genesis := testBlock(testHeader(nil, 0))
+	return &testChain{
+		blocks:    map[types.Hash]*models.Block{genesis.Hash(): genesis},
+		canonical: map[uint64]types.Hash{0: genesis.Hash()},
+		head:      genesis,
+	}
+}
+
+// extend 将headers对应的区块写入并设置为链头，不发送链头事件
+func (c *testChain) extend(headers []*models.Header) []*models.Block {
+	blocks := make([]*models.Block, len(headers))
+	for i, header := range headers {
+		blocks[i] = testBlock(header)
+	}
+	c.extendBlocks(blocks)
+	return blocks
+}
+
+// extendBlocks 将区块写入并设置为链头，不发送链头事件
+func (c *testChain) extendBlocks(blocks []*models.Block) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, block := range blocks {
+		c.blocks[block.Hash()] = block
+		c.canonical[block.Height()] = block.Hash()
+		c.head = block
+	}
+}
+
+func (c *testChain) InsertBlock(block *models.Block, propagate bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.blocks[block.Hash()] = block
+	c.canonical[block.Height()] = block.Hash()
+	c.inserted = append(c.inserted, block.Height())
+	return nil
+}
+
+func (c *testChain) ProcessBlock(block *models.Block, propagate bool) error {
+	if c.process != nil {
+		if err := c.process(block); err != nil {
+			return err
+		}
+	}
+	c.lock.Lock()
+	c.blocks[block.Hash()] = block
+	c.canonical[block.Height()] = block.Hash()
+	c.head = block
+	c.lock.Unlock()
+
+	c.feed.Send(eventtype.ChainHeadEvent{Block: block})
+	return nil
+}
+
+func (c *testChain) Start() error    { return nil }
+func (c *testChain) Stop() error     { return nil }
+func (c *testChain) IsRunning() bool { return true }
+func (c *testChain) CurrentHeader() *models.Header {
+	return c.CurrentBlock().Header()
+}
+func (c *testChain) GetHeader(hash types.Hash, number uint64) *models.Header {
+	if block := c.GetBlock(hash, number); block != nil {
+		return block.Header()
+	}
+	return nil
+}
+func (c *testChain) GetHeaderByHash(hash types.Hash) *models.Header {
+	if block := c.GetBlockByHash(hash); block != nil {
+		return block.Header()
+	}
+	return nil
+}
+func (c *testChain) GetHeaderByNumber(number uint64) *models.Header {
+	if block := c.GetBlockByNumber(number); block != nil {
+		return block.Header()
+	}
+	return nil
+}
+func (c *testChain) HasHeader(hash types.Hash, number uint64) bool {
+	return c.GetBlock(hash, number) != nil
+}
+func (c *testChain) CurrentBlock() *models.Block {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.head
+}
+func (c *testChain) GetBlock(hash types.Hash, number uint64) *models.Block {
+	if block := c.GetBlockByHash(hash); block != nil && block.Height() == number {
+		return block
+	}
+	return nil
+}
+func (c *testChain) GetBlockByHash(hash types.Hash) *models.Block {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.blocks[hash]
+}
+func (c *testChain) GetBlockByNumber(number uint64) *models.Block {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if hash, ok := c.canonical[number]; ok {
+		return c.blocks[hash]
+	}
+	return nil
+}
+func (c *testChain) HasBlock(hash types.Hash, number uint64) bool {
+	return c.GetBlock(hash, number) != nil
+}
+func (c *testChain) GetBlockHashesFromHash(hash types.Hash, max uint64) []types.Hash { return nil }
+func (c *testChain) GetBody(hash types.Hash) *models.Body {
+	if block := c.GetBlockByHash(hash); block != nil {
+		return &models.Body{Height: block.Height(), Txs: block.Transactions()}
+	}
+	return nil
+}
+func (c *testChain) ValidateBody(block *models.Block) error { return nil }
+func (c *testChain) GetAncestor(hash types.Hash, number, ancestor uint64, maxNonCanonical *uint64) (types.Hash, uint64) {
+	return types.Hash{}, 0
+}
+func (c *testChain) SubscribeChainHeadEvent(ch chan<- eventtype.ChainHeadEvent) event.Subscription {
+	return c.feed.Subscribe(ch)
+}
+
+func (c *testChain) insertedHeights() []uint64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]uint64(nil), c.inserted...)
+}
+
+var _ protocol.BlockReadWriter = new(testChain)
+
+// newTestSyncer 使用模拟的p2p服务、handshake及时钟创建syncer，不启动
+func newTestSyncer(t testing.TB, chain *testChain, opts ...option) (*syncer, *replayP2P) {
+	t.Helper()
+	clock := new(mclock.Simulated)
+	p2p := newReplayP2P(clock)
+	opts = append([]option{
+		WithP2PService(p2p),
+		WithHandshake(new(replayHandshake)),
+		WithBlockRW(chain),
+		WithClock(clock),
+	}, opts...)
+	s, err := NewSyncer(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("new syncer: %v", err)
+	}
+	return s.(*syncer), p2p
+}
+
+// registerTestPeer 注册已完成状态交换的节点
+func registerTestPeer(s *syncer, id models.P2PID, head ChainHead) *peer {
+	peer := newPeer(s.p2p, id)
+	peer.SetChainHead(head)
+	s.peers.Register(peer, peer.sendLoop)
+	return peer
+}
+
+// testVerifier 按高度返回预设错误的提交证书校验
+type testVerifier struct {
+	commits  map[uint64]error // VerifyCommit按高度返回的错误
+	nexts    map[uint64]error // NextValidators按高度返回的错误
+	fromNext bool             // 证书由子区块携带，next为空时证书缺失
+}
+
+func (v *testVerifier) Validators(parent *models.Header) (*ValidatorSet, error) {
+	return &ValidatorSet{}, nil
+}
+
+func (v *testVerifier) VerifyCommit(header *models.Header, next *models.Header, validators *ValidatorSet) error {
+	if v.fromNext && next == nil {
+		return ErrNoCommit
+	}
+	return v.commits[header.Height]
+}
+
+func (v *testVerifier) NextValidators(header *models.Header, validators *ValidatorSet) (*ValidatorSet, error) {
+	return nil, v.nexts[header.Height]
+}
+
+// driveImports 代替syncBlocks处理区块完成的通知，并推进模拟时钟以检查导入进度
+func driveImports(s *syncer) {
+	clock := s.clock.(*mclock.Simulated)
+	go func() {
+		for {
+			select {
+			case <-s.blockCompletedCh:
+				s.blockCompleted()
+			case <-time.After(time.Millisecond):
+				clock.Run(importPollInterval)
+			case <-s.quitCh:
+				return
+			}
+		}
+	}()
+}
+
+// drainCompleted 代替syncBlocks丢弃区块完成的通知
+func drainCompleted(s *syncer) {
+	go func() {
+		for {
+			select {
+			case <-s.blockCompletedCh:
+			case <-s.quitCh:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,58 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"sync/atomic"
+)
+
+var errOutOfRange = errors.New("requested blocks outside served range")
+
+// notAvailableData 请求的区块不在本节点可提供的范围内时的响应
+type notAvailableData struct {
+	Code        uint64           // 请求的消息类型
+	Origin      ext.HashOrNumber // header请求的起点
+	Hash        types.Hash       // body请求的第一个区块hash
+	HistoryFrom uint64           // 本节点可提供的最低高度
+}
+
+// HistoryFrom 本节点可提供完整区块的最低高度，0表示全部历史
+func (s *syncer) HistoryFrom() uint64 {
+	return atomic.LoadUint64(&s.historyFrom)
+}
+
+// SetHistoryFrom 区块裁剪后更新可提供的最低高度，并通过状态交换通知已连接的节点
+func (s *syncer) SetHistoryFrom(from uint64) {
+	if atomic.SwapUint64(&s.historyFrom, from) == from {
+		return
+	}
+	// 已缓存的响应可能包含已裁剪的区块
+	s.serveCache.purge()
+	for _, peer := range s.peers.Peers() {
+		go s.sendStatus(peer.P2PID)
+	}
+	s.log.Info("Served history range changed", "from", from)
+}
+
+// sendNotAvailable 告知远程节点请求的区块不可提供，避免对方反复重试
+func (s *syncer) sendNotAvailable(peerId models.P2PID, data *notAvailableData) {
+	data.HistoryFrom = s.HistoryFrom()
+	if err := s.sendMsg(peerId, NotAvailableMsg, data); err != nil {
+		s.log.Error("send notAvailable err", "peer", peerId, "err", err)
+	}
+}
+
+// handleNotAvailable 更新远程节点的可提供范围，并结束对应的请求
+func (s *syncer) handleNotAvailable(peerId models.P2PID, data *notAvailableData) {
+	if peer := s.peers.Peer(peerId); peer != nil {
+		peer.SetHistoryFrom(data.HistoryFrom)
+	}
+	if !s.fetcher.fail(peerId, data, errOutOfRange) {
+		s.log.Debug("Unrequested notAvailable", "peer", peerId, "code", data.Code)
+	}
+}
@@ -0,0 +1,169 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+)
+
+const (
+	maxKnownBlocks = 1024  // 每个节点记录的已知区块个数
+	maxKnownTxs    = 32768 // 每个节点记录的已知交易个数
+)
+
+// blockAnnounce 新区块的通知
+type blockAnnounce struct {
+	Hash   types.Hash // 区块hash
+	Number uint64     // 区块高度
+}
+
+// markBlocks 记录节点已拥有的区块
+func (p *peer) markBlocks(hashes ...types.Hash) {
+	for _, hash := range hashes {
+		p.knownBlocks.Add(hash, struct{}{})
+	}
+}
+
+// markTxs 记录节点已拥有的交易
+func (p *peer) markTxs(txs models.Transactions) {
+	for _, list := range txs {
+		for _, tx := range list {
+			p.knownTxs.Add(tx.Hash(), struct{}{})
+		}
+	}
+}
+
+// KnownBlock 节点是否已拥有该区块
+func (p *peer) KnownBlock(hash types.Hash) bool {
+	return p.knownBlocks.Contains(hash)
+}
+
+// KnownTx 节点是否已拥有该交易
+func (p *peer) KnownTx(hash types.Hash) bool {
+	return p.knownTxs.Contains(hash)
+}
+
+// markHeaders 收发header时记录对方已拥有的区块
+func (s *syncer) markHeaders(peerId models.P2PID, headers []*models.Header) {
+	peer := s.peers.Peer(peerId)
+	if peer == nil {
+		return
+	}
+	for _, header := range headers {
+		if header != nil {
+			peer.markBlocks(header.Hash())
+		}
+	}
+}
+
+// markBodies 收发body时记录对方已拥有的交易
+func (s *syncer) markBodies(peerId models.P2PID, bodies []*models.Body) {
+	peer := s.peers.Peer(peerId)
+	if peer == nil {
+		return
+	}
+	for _, body := range bodies {
+		if body != nil {
+			peer.markTxs(body.Txs)
+		}
+	}
+}
+
+// AnnounceBlock 向尚未拥有该区块的节点通知新区块
+func (s *syncer) AnnounceBlock(block *models.Block) {
+	hash := block.Hash()
+	announces := []blockAnnounce{{Hash: hash, Number: block.Height()}}
+	for _, peer := range s.peers.Peers() {
+		if peer.KnownBlock(hash) {
+			continue
+		}
+		bytes, err := s.encodeMsg(peer.P2PID, NewBlockHashesMsg, announces)
+		if err != nil {
+			s.log.Error("announce encode err", "peer", peer.P2PID, "err", err)
+			continue
+		}
+		peer.markBlocks(hash)
+		peer.queue.push(&models.P2PMessage{
+			Type: NewBlockHashesMsg,
+			Peer: "",
+			Data: bytes,
+		})
+	}
+}
+
+// BroadcastTxs 向各节点发送其尚未拥有的交易
+func (s *syncer) BroadcastTxs(txs models.Transactions) {
+	for _, peer := range s.peers.Peers() {
+		var unknown models.Transactions
+		for _, list := range txs {
+			var filtered []models.Transaction
+			for _, tx := range list {
+				if !peer.KnownTx(tx.Hash()) {
+					filtered = append(filtered, tx)
+				}
+			}
+			if len(filtered) > 0 {
+				unknown = append(unknown, models.NewTransactionSortedList(filtered))
+			}
+		}
+		if len(unknown) == 0 {
+			continue
+		}
+		bytes, err := s.encodeMsg(peer.P2PID, TxMsg, unknown)
+		if err != nil {
+			s.log.Error("txs encode err", "peer", peer.P2PID, "err", err)
+			continue
+		}
+		peer.markTxs(unknown)
+		peer.queue.push(&models.P2PMessage{
+			Type: TxMsg,
+			Peer: "",
+			Data: bytes,
+		})
+	}
+}
+
+// handleAnnounces 处理远程节点的新区块通知
+func (s *syncer) handleAnnounces(peerId models.P2PID, announces []blockAnnounce) {
+	peer := s.peers.Peer(peerId)
+	if peer == nil {
+		return
+	}
+	var highest *blockAnnounce
+	for i, announce := range announces {
+		if s.isBadBlock(announce.Hash) {
+			s.log.Warn("drop bad block announce", "peer", peerId, "number", announce.Number, "hash", announce.Hash)
+			s.penalize(peerId, errBadBlock)
+			return
+		}
+		peer.markBlocks(announce.Hash)
+		if highest == nil || announce.Number > highest.Number {
+			highest = &announces[i]
+		}
+	}
+	// 通知的区块即对方的最新链头
+	if highest != nil {
+		s.updatePeerHead(peer, ChainHead{Hash: highest.Hash, Height: highest.Number})
+	}
+}
+
+// handleTxs 处理远程节点发送的交易，放入交易池
+func (s *syncer) handleTxs(peerId models.P2PID, txs models.Transactions) {
+	peer := s.peers.Peer(peerId)
+	if peer == nil {
+		return
+	}
+	peer.markTxs(txs)
+	if s.txPools == nil {
+		return
+	}
+	for _, list := range txs {
+		for _, tx := range list {
+			if err := s.txPools.Add(&peerId, tx); err != nil {
+				s.log.Debug("add remote tx err", "peer", peerId, "hash", tx.Hash(), "err", err)
+			}
+		}
+	}
+}
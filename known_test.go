@@ -0,0 +1,105 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"testing"
+)
+
+// queued 取出发送队列中的所有消息，返回code消息的个数
+func queued(p *peer, code uint) int {
+	n := 0
+	for msg := p.queue.pop(); msg != nil; msg = p.queue.pop() {
+		if msg.Type == code {
+			n++
+		}
+	}
+	return n
+}
+
+// newKnownTestPeers 注册不启动发送协程的节点，消息留在发送队列中
+func newKnownTestPeers(s *syncer, ids ...models.P2PID) []*peer {
+	peers := make([]*peer, len(ids))
+	for i, id := range ids {
+		peers[i] = newPeer(s.p2p, id)
+		s.peers.Register(peers[i], nil)
+	}
+	return peers
+}
+
+func TestSendBlockBodiesMarksServed(t *testing.T) {
+	chain := newTestChain()
+	txs := testTxs(1)
+	block := testTxBlock(testTxHeader(chain.GetHeaderByNumber(0), txs), txs)
+	chain.extendBlocks([]*models.Block{block})
+	s, _ := newTestSyncer(t, chain)
+	defer s.Stop()
+
+	remote := newKnownTestPeers(s, "remote")[0]
+	missing := types.Hash{1}
+	s.SendBlockBodies(remote.P2PID, []types.Hash{block.Hash(), missing})
+
+	if queued(remote, BlockBodiesMsg) != 1 {
+		t.Fatalf("bodies not sent")
+	}
+	if !remote.KnownBlock(block.Hash()) {
+		t.Fatalf("sent block not marked known")
+	}
+	if remote.KnownBlock(missing) {
+		t.Fatalf("missing block marked known")
+	}
+}
+
+func TestAnnounceBlockSkipsKnownPeers(t *testing.T) {
+	chain := newTestChain()
+	block := chain.extend(testHeaders(chain.GetHeaderByNumber(0), 1, 0))[0]
+	s, _ := newTestSyncer(t, chain)
+	defer s.Stop()
+
+	peers := newKnownTestPeers(s, "a-unknown", "b-known")
+	peers[1].markBlocks(block.Hash())
+
+	s.AnnounceBlock(block)
+	for i, want := range []int{1, 0} {
+		if n := queued(peers[i], NewBlockHashesMsg); n != want {
+			t.Fatalf("%s announces = %d, want %d", peers[i].P2PID, n, want)
+		}
+	}
+	// 已通知的节点不再重复通知
+	s.AnnounceBlock(block)
+	for _, p := range peers {
+		if n := queued(p, NewBlockHashesMsg); n != 0 {
+			t.Fatalf("%s re-announced %d times", p.P2PID, n)
+		}
+	}
+}
+
+func TestBroadcastTxsSkipsKnownTxs(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain())
+	defer s.Stop()
+
+	txs := testTxs(1, 2)
+	peers := newKnownTestPeers(s, "a-partial", "b-known")
+	peers[0].markTxs(testTxs(1))
+	peers[1].markTxs(txs)
+
+	s.BroadcastTxs(txs)
+	for i, want := range []int{1, 0} {
+		if n := queued(peers[i], TxMsg); n != want {
+			t.Fatalf("%s tx broadcasts = %d, want %d", peers[i].P2PID, n, want)
+		}
+	}
+	if !peers[0].KnownTx(txs[0][1].Hash()) {
+		t.Fatalf("broadcast tx not marked known")
+	}
+	// 已发送的交易不再重复发送
+	s.BroadcastTxs(txs)
+	for _, p := range peers {
+		if n := queued(p, TxMsg); n != 0 {
+			t.Fatalf("%s rebroadcast %d times", p.P2PID, n)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"time"
+)
+
+// SyncMode 同步模式
+type SyncMode int
+
+const (
+	FullSync  SyncMode = iota // 同步完整区块
+	LightSync                 // 只同步header，body按需获取
+)
+
+func (mode SyncMode) String() string {
+	switch mode {
+	case FullSync:
+		return "full"
+	case LightSync:
+		return "light"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(mode))
+	}
+}
+
+const (
+	odrTimeout  = 5 * time.Second // 单次按需请求的超时时间
+	odrMaxPeers = 3               // 按需请求最多尝试的节点个数
+)
+
+var (
+	errNoHeaderStore  = errors.New("light sync requires a header store")
+	errNoBlockRW      = errors.New("full sync requires a block reader writer")
+	errUnknownHeader  = errors.New("unknown header")
+	errUnlinkedHeader = errors.New("header chain is not linked")
+	errInvalidBody    = errors.New("body does not match header")
+	errNoPeers        = errors.New("no peers available")
+	errTxNotFound     = errors.New("transaction not found in block")
+)
+
+// HeaderStore 轻节点的header存储
+type HeaderStore interface {
+	// CurrentHeader 当前header
+	CurrentHeader() *models.Header
+	// GetHeaderByHash 根据区块hash获取header
+	GetHeaderByHash(hash types.Hash) *models.Header
+	// GetHeaderByNumber 根据区块高度获取header
+	GetHeaderByNumber(number uint64) *models.Header
+	// WriteHeaders 写入已校验的连续header
+	WriteHeaders(headers []*models.Header) error
+}
+
+// headerServeStore 没有blockRW的轻节点从header存储对外提供header，不提供body
+type headerServeStore struct {
+	HeaderStore
+}
+
+func (h headerServeStore) GetHeader(hash types.Hash, number uint64) *models.Header {
+	header := h.GetHeaderByHash(hash)
+	if header == nil || header.Height != number {
+		return nil
+	}
+	return header
+}
+
+func (h headerServeStore) GetBody(hash types.Hash) *models.Body {
+	return nil
+}
+
+// emptyBody 根据header判断区块是否没有交易，这类区块不需要下载body
+func emptyBody(header *models.Header) bool {
+	return header.TxsCount == 0 && len(header.TxsRoot) == 0
+}
+
+// verifyBody 校验body与header中的交易根是否一致
+func verifyBody(header *models.Header, body *models.Body) error {
+	if body == nil || body.Height != header.Height {
+		return errInvalidBody
+	}
+	for _, list := range body.Txs {
+		if list.Len() == 0 {
+			return errInvalidBody
+		}
+	}
+	root := body.Txs.TxsRoot()
+	if len(root) != len(header.TxsRoot) {
+		return errInvalidBody
+	}
+	for i := range root {
+		if root[i] != header.TxsRoot[i] {
+			return errInvalidBody
+		}
+	}
+	return nil
+}
+
+// localHeight 本地已同步的高度，轻节点为header的高度
+func (s *syncer) localHeight() uint64 {
+	if s.mode == LightSync {
+		return s.headerStore.CurrentHeader().Height
+	}
+	return s.blockRW.CurrentBlock().Height()
+}
+
+// localHeader 根据hash获取本地已校验的header
+func (s *syncer) localHeader(hash types.Hash) *models.Header {
+	if s.mode == LightSync {
+		return s.headerStore.GetHeaderByHash(hash)
+	}
+	return s.blockRW.GetHeaderByHash(hash)
+}
+
+// localHeaderByNumber 根据高度获取本地已校验的header
+func (s *syncer) localHeaderByNumber(number uint64) *models.Header {
+	if s.mode == LightSync {
+		return s.headerStore.GetHeaderByNumber(number)
+	}
+	return s.blockRW.GetHeaderByNumber(number)
+}
+
+// insertHeaders 轻节点校验header的连续性后写入header存储
+func (s *syncer) insertHeaders(source string, headers []*models.Header) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	first := headers[0]
+	if first.Height == 0 {
+		return fmt.Errorf("%w: unexpected genesis header", errUnlinkedHeader)
+	}
+	parent := s.headerStore.GetHeaderByNumber(first.Height - 1)
+	if parent == nil || parent.Hash() != first.ParentHash {
+		return fmt.Errorf("%w: height=%d parent=%s", errUnlinkedHeader, first.Height, first.ParentHash.Hex())
+	}
+	for i := 1; i < len(headers); i++ {
+		if headers[i].Height != headers[i-1].Height+1 || headers[i].ParentHash != headers[i-1].Hash() {
+			return fmt.Errorf("%w: height=%d", errUnlinkedHeader, headers[i].Height)
+		}
+	}
+	if err := s.headerStore.WriteHeaders(headers); err != nil {
+		return err
+	}
+	s.updateHeadFromHeaders(models.P2PID(source), headers)
+	s.log.Debug("Imported headers", "source", source, "count", len(headers), "height", headers[len(headers)-1].Height)
+	return nil
+}
+
+// GetBlockBody 按需从远程节点获取区块的body，并用本地已校验的header进行校验
+func (s *syncer) GetBlockBody(ctx context.Context, blockHash types.Hash) (*models.Body, error) {
+	header := s.localHeader(blockHash)
+	if header == nil {
+		return nil, errUnknownHeader
+	}
+	if emptyBody(header) {
+		return &models.Body{Height: header.Height}, nil
+	}
+	if s.mode != LightSync {
+		if body := s.blockRW.GetBody(blockHash); body != nil {
+			return body, nil
+		}
+	}
+
+	tried := make(map[models.P2PID]bool)
+	for i := 0; i < odrMaxPeers; i++ {
+		peer := s.peers.BestPeerFor(header.Height, header.Height, tried)
+		if peer == nil {
+			break
+		}
+		tried[peer.P2PID] = true
+
+		body, err := s.requestBody(ctx, peer, header)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		s.log.Debug("On-demand body retrieval failed", "peer", peer.P2PID, "hash", blockHash, "err", err)
+	}
+	if len(tried) == 0 {
+		return nil, errNoPeers
+	}
+	return nil, fmt.Errorf("retrieve body %s failed after %d peers", blockHash.Hex(), len(tried))
+}
+
+// GetTransaction 按需获取区块中的交易
+func (s *syncer) GetTransaction(ctx context.Context, blockHash types.Hash, txHash types.Hash) (models.Transaction, error) {
+	body, err := s.GetBlockBody(ctx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range body.Txs {
+		for _, tx := range list {
+			if tx.Hash() == txHash {
+				return tx, nil
+			}
+		}
+	}
+	return nil, errTxNotFound
+}
+
+func (s *syncer) requestBody(ctx context.Context, peer *peer, header *models.Header) (*models.Body, error) {
+	req := s.fetcher.addBodies(peer.P2PID, []types.Hash{header.Hash()}, []uint64{header.Height})
+	defer s.fetcher.remove(req)
+
+	if err := s.RequestBlockBodies(peer.P2PID, req.hashes); err != nil {
+		return nil, err
+	}
+	select {
+	case bodies := <-req.bodies:
+		if len(bodies) == 0 || verifyBody(header, bodies[0]) != nil {
+			return nil, errInvalidBody
+		}
+		return bodies[0], nil
+	case err := <-req.failed:
+		return nil, err
+	case <-s.clock.After(odrTimeout):
+		return nil, errFetchTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.quitCh:
+		return nil, errStopped
+	}
+}
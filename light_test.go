@@ -0,0 +1,182 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHeaderStore 内存中的轻节点header存储
+type testHeaderStore struct {
+	hashes  map[types.Hash]*models.Header
+	numbers map[uint64]*models.Header
+	head    *models.Header
+	lock    sync.RWMutex
+}
+
+func newTestHeaderStore(genesis *models.Header) *testHeaderStore {
+	store := &testHeaderStore{
+		hashes:  make(map[types.Hash]*models.Header),
+		numbers: make(map[uint64]*models.Header),
+	}
+	store.WriteHeaders([]*models.Header{genesis})
+	return store
+}
+
+func (s *testHeaderStore) CurrentHeader() *models.Header {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.head
+}
+
+func (s *testHeaderStore) GetHeaderByHash(hash types.Hash) *models.Header {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.hashes[hash]
+}
+
+func (s *testHeaderStore) GetHeaderByNumber(number uint64) *models.Header {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.numbers[number]
+}
+
+func (s *testHeaderStore) WriteHeaders(headers []*models.Header) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, header := range headers {
+		s.hashes[header.Hash()] = header
+		s.numbers[header.Height] = header
+		s.head = header
+	}
+	return nil
+}
+
+func TestInsertHeadersLightMode(t *testing.T) {
+	genesis := testHeader(nil, 0)
+	chain := testHeaders(genesis, 4, 0)
+	fork := testHeaders(genesis, 4, 1)
+
+	tests := []struct {
+		name    string
+		headers []*models.Header
+		err     error
+		height  uint64
+	}{
+		{name: "empty", headers: nil},
+		{name: "linked", headers: chain, height: 4},
+		{name: "partial", headers: chain[:2], height: 2},
+		{name: "genesis", headers: []*models.Header{genesis}, err: errUnlinkedHeader},
+		{name: "unknown parent", headers: chain[1:], err: errUnlinkedHeader},
+		{name: "gap", headers: []*models.Header{chain[0], chain[2]}, err: errUnlinkedHeader},
+		{name: "mixed fork", headers: []*models.Header{chain[0], fork[1]}, err: errUnlinkedHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestHeaderStore(genesis)
+			s, _ := newTestSyncer(t, newTestChain(), WithSyncMode(LightSync), WithHeaderStore(store))
+
+			err := s.insertHeaders("test", tt.headers)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("insertHeaders err = %v, want %v", err, tt.err)
+			}
+			if height := s.localHeight(); height != tt.height {
+				t.Fatalf("local height = %d, want %d", height, tt.height)
+			}
+			for _, header := range tt.headers {
+				stored := store.GetHeaderByHash(header.Hash()) != nil
+				if header.Height > 0 && stored != (tt.err == nil) {
+					t.Fatalf("header %d stored = %t, want %t", header.Height, stored, tt.err == nil)
+				}
+			}
+		})
+	}
+}
+
+func TestLightModeWithoutBlockRW(t *testing.T) {
+	if _, err := NewSyncer(context.Background(), WithBlockRW(nil)); !errors.Is(err, errNoBlockRW) {
+		t.Fatalf("full sync without blockRW err = %v, want %v", err, errNoBlockRW)
+	}
+
+	genesis := testHeader(nil, 0)
+	store := newTestHeaderStore(genesis)
+	headers := testHeaders(genesis, 2, 0)
+	store.WriteHeaders(headers)
+	s, p2p := newTestSyncer(t, newTestChain(), WithBlockRW(nil), WithSyncMode(LightSync), WithHeaderStore(store))
+	if err := s.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer s.Stop()
+	waitSubscribed(t, s, p2p)
+
+	// 只有header存储时从header存储提供header，不提供body
+	if header := s.serveCache.GetHeaderByNumber(2); header == nil || header.Hash() != headers[1].Hash() {
+		t.Fatalf("served header 2 = %v, want %s", header, headers[1].Hash())
+	}
+	if header := s.serveCache.GetHeader(headers[0].Hash(), 2); header != nil {
+		t.Fatalf("served header with mismatched height")
+	}
+	if body := s.serveCache.GetBody(headers[0].Hash()); body != nil {
+		t.Fatalf("served body without blockRW")
+	}
+}
+
+func TestGetBlockBodyVerifiesHeader(t *testing.T) {
+	txs := testTxs(1, 2)
+	tests := []struct {
+		name string
+		body models.Transactions
+		err  bool
+	}{
+		{name: "matching", body: txs},
+		{name: "missing tx", body: testTxs(1), err: true},
+		{name: "other tx", body: testTxs(1, 3), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			genesis := testHeader(nil, 0)
+			store := newTestHeaderStore(genesis)
+			header := testTxHeader(genesis, txs)
+			store.WriteHeaders([]*models.Header{header})
+			s, _ := newTestSyncer(t, newTestChain(), WithBlockRW(nil), WithSyncMode(LightSync), WithHeaderStore(store))
+			defer s.Stop()
+
+			// 不启动发送协程，请求留在发送队列中
+			const id = models.P2PID("remote")
+			remote := newPeer(s.p2p, id)
+			remote.SetChainHead(ChainHead{Height: header.Height})
+			s.peers.Register(remote, nil)
+
+			type result struct {
+				tx  models.Transaction
+				err error
+			}
+			done := make(chan result, 1)
+			go func() {
+				tx, err := s.GetTransaction(context.Background(), header.Hash(), txs[0][1].Hash())
+				done <- result{tx, err}
+			}()
+			for deadline := time.Now().Add(time.Second); len(requestedPeers([]*peer{remote}, GetBlockBodiesMsg)) == 0; time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("body not requested")
+				}
+			}
+			s.HandleBlockBodiesMsg(id, []*models.Body{{Height: header.Height, Txs: tt.body}})
+
+			res := <-done
+			if (res.err != nil) != tt.err {
+				t.Fatalf("GetTransaction err = %v, want error %t", res.err, tt.err)
+			}
+			if !tt.err && (res.tx == nil || res.tx.Hash() != txs[0][1].Hash()) {
+				t.Fatalf("GetTransaction = %v, want %s", res.tx, txs[0][1].Hash())
+			}
+		})
+	}
+}
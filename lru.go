@@ -0,0 +1,143 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache 并发安全的定长LRU缓存
+type lruCache struct {
+	size  int
+	items map[interface{}]*list.Element
+	order *list.List // 队首为最近使用
+	mu    sync.Mutex
+}
+
+type lruEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		items: make(map[interface{}]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Add 添加或更新，超出容量时淘汰最久未使用的数据
+func (c *lruCache) Add(key, value interface{}) {
+	if c == nil || c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*lruEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Get 获取数据，并将其标记为最近使用
+func (c *lruCache) Get(key interface{}) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+// Peek 获取数据，不改变使用顺序
+func (c *lruCache) Peek(key interface{}) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*lruEntry).value, true
+}
+
+// Contains 判断是否存在，不改变使用顺序
+func (c *lruCache) Contains(key interface{}) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.items[key]
+	return ok
+}
+
+func (c *lruCache) Remove(key interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+// Purge 清空缓存
+func (c *lruCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[interface{}]*list.Element)
+	c.order.Init()
+}
+
+func (c *lruCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Keys 按最近使用到最久未使用的顺序返回所有key
+func (c *lruCache) Keys() []interface{} {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]interface{}, 0, c.order.Len())
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*lruEntry).key)
+	}
+	return keys
+}
@@ -4,8 +4,15 @@
 package syncer
 
 import (
+	"errors"
 	"fmt"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
 	"github.com/chain5j/chain5j-protocol/protocol"
+	"io"
+	"time"
 )
 
 type option func(f *syncer) error
@@ -34,6 +41,14 @@ func WithApps(apps protocol.Apps) option {
 		return nil
 	}
 }
+
+// WithTxPools 设置交易池，接收到的远程交易会放入交易池
+func WithTxPools(txPools protocol.TxPools) option {
+	return func(f *syncer) error {
+		f.txPools = txPools
+		return nil
+	}
+}
 func WithBlockRW(blockRW protocol.BlockReadWriter) option {
 	return func(f *syncer) error {
 		f.blockRW = blockRW
@@ -47,3 +62,212 @@ func WithHandshake(handshake protocol.Handshake) option {
 		return nil
 	}
 }
+
+// WithNetworkId 设置网络ID，状态交换时网络ID不一致的节点会被拒绝
+func WithNetworkId(networkId uint64) option {
+	return func(f *syncer) error {
+		f.networkId = networkId
+		return nil
+	}
+}
+
+// WithGenesisHash 设置创世块hash，未设置时从blockRW中读取
+func WithGenesisHash(genesis types.Hash) option {
+	return func(f *syncer) error {
+		f.genesis = genesis
+		return nil
+	}
+}
+
+// WithSyncMode 设置同步模式
+func WithSyncMode(mode SyncMode) option {
+	return func(f *syncer) error {
+		if mode != FullSync && mode != LightSync {
+			return fmt.Errorf("unknown sync mode: %s", mode)
+		}
+		f.mode = mode
+		return nil
+	}
+}
+
+// WithHeaderStore 设置轻节点的header存储
+func WithHeaderStore(headerStore HeaderStore) option {
+	return func(f *syncer) error {
+		f.headerStore = headerStore
+		return nil
+	}
+}
+
+// WithServeCache 设置服务端缓存，各项为0时关闭对应缓存
+func WithServeCache(config ServeCacheConfig) option {
+	return func(f *syncer) error {
+		if config.Headers < 0 || config.Bodies < 0 || config.Payloads < 0 {
+			return fmt.Errorf("invalid serve cache config: %+v", config)
+		}
+		f.serveCacheConfig = config
+		return nil
+	}
+}
+
+// WithBlockSources 设置额外的区块来源，按顺序优先于p2p使用
+func WithBlockSources(sources ...BlockSource) option {
+	return func(f *syncer) error {
+		for _, source := range sources {
+			if source == nil {
+				return errors.New("nil block source")
+			}
+		}
+		f.sources.sources = append(f.sources.sources, sources...)
+		return nil
+	}
+}
+
+// WithCheckpoint 设置可信检查点，从检查点开始跟随链头，并在后台回填检查点之前的历史区块。
+// 检查点区块写入后，链需以其为当前区块(状态通过快照等方式获得)
+func WithCheckpoint(checkpoint Checkpoint) option {
+	return func(f *syncer) error {
+		if checkpoint.Hash == (types.Hash{}) {
+			return fmt.Errorf("%w: empty hash", errCheckpointInvalid)
+		}
+		f.backfill = newBackfill(checkpoint)
+		return nil
+	}
+}
+
+// WithHistoryFrom 设置本节点可提供完整区块的最低高度，用于裁剪了历史区块的节点
+func WithHistoryFrom(from uint64) option {
+	return func(f *syncer) error {
+		f.historyFrom = from
+		return nil
+	}
+}
+
+// WithCompression 设置批量同步数据的压缩算法，只有对方也支持时才会压缩
+func WithCompression(compression Compression) option {
+	return func(f *syncer) error {
+		if compression != CompressionNone && compression != CompressionSnappy {
+			return fmt.Errorf("%w: %s", errUnknownCompression, compression)
+		}
+		f.compression = compression
+		return nil
+	}
+}
+
+// WithStateStore 设置同步状态的持久化存储，重启后恢复同步目标、检查点、回填进度、禁止的节点及无效区块
+func WithStateStore(store protocol.KVStore) option {
+	return func(f *syncer) error {
+		f.stateStore = store
+		return nil
+	}
+}
+
+// WithChainWeigher 设置链权重的计算方式，用于状态交换及链头比较
+func WithChainWeigher(weigher ChainWeigher) option {
+	return func(f *syncer) error {
+		f.weigher = weigher
+		return nil
+	}
+}
+
+// WithHeadComparator 设置链头比较器，默认为CompareByWeight
+func WithHeadComparator(compare HeadComparator) option {
+	return func(f *syncer) error {
+		f.compare = compare
+		return nil
+	}
+}
+
+// WithClock 设置定时器及请求超时使用的时钟，默认为系统时钟
+func WithClock(clock mclock.Clock) option {
+	return func(f *syncer) error {
+		f.clock = clock
+		return nil
+	}
+}
+
+// WithTraceRecorder 将同步相关的收发消息、handshake、节点断开及定时器事件记录到w，可通过ReplayTrace回放
+func WithTraceRecorder(w io.Writer) option {
+	return func(f *syncer) error {
+		tracer, err := newTraceRecorder(w)
+		if err != nil {
+			return err
+		}
+		f.tracer = tracer
+		return nil
+	}
+}
+
+// WithCodec 增加消息编码并作为发送时优先使用的编码，可多次设置以同时支持多种编码，最后设置的优先。
+// 版本号0保留给默认的RLP编码，对方不支持该编码时仍使用RLP
+func WithCodec(version CodecVersion, c codec.Codec) option {
+	return func(f *syncer) error {
+		if version == CodecRLP {
+			return errReservedCodec
+		}
+		if c == nil {
+			return fmt.Errorf("%w: nil codec", errUnknownCodec)
+		}
+		f.codecs.codecs[version] = c
+		f.codecs.preferred = version
+		return nil
+	}
+}
+
+// WithStaleHeadTimeout 设置节点链头的过期时间，超过该时间未更新链头的节点会被单独请求handshake
+func WithStaleHeadTimeout(timeout time.Duration) option {
+	return func(f *syncer) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid stale head timeout: %v", timeout)
+		}
+		f.staleHeadTimeout = timeout
+		return nil
+	}
+}
+
+// WithFinality 设置对区块最终性的要求及提交证书的校验方式
+func WithFinality(mode FinalityMode, verifier FinalityVerifier) option {
+	return func(f *syncer) error {
+		if mode < FinalityOff || mode > FinalityRequired {
+			return fmt.Errorf("unknown finality mode: %s", mode)
+		}
+		f.finality = mode
+		if verifier != nil {
+			f.validators = newValidatorSets(verifier)
+		}
+		return nil
+	}
+}
+
+// WithPeerSelector 设置同步下载及按需获取时的节点选择策略，默认为SelectByHead
+func WithPeerSelector(selector PeerSelector) option {
+	return func(f *syncer) error {
+		f.selector = selector
+		return nil
+	}
+}
+
+// WithSyncSources 设置同步来源的选择方式及允许、拒绝列表，运行时可通过AllowSyncSources等修改
+func WithSyncSources(mode SyncSourceMode, allow []models.P2PID, deny []models.P2PID) option {
+	return func(f *syncer) error {
+		if mode != SyncFromAny && mode != SyncFromAllowed {
+			return fmt.Errorf("unknown sync source mode: %s", mode)
+		}
+		f.policy.mode = mode
+		for _, id := range allow {
+			f.policy.allow[id] = true
+		}
+		for _, id := range deny {
+			f.policy.deny[id] = true
+			delete(f.policy.allow, id)
+		}
+		return nil
+	}
+}
+
+// WithSyncSourceFilter 设置判断同步来源的回调，用于不在静态列表中的节点
+func WithSyncSourceFilter(filter SyncSourceFilter) option {
+	return func(f *syncer) error {
+		f.policy.filter = filter
+		return nil
+	}
+}
@@ -5,9 +5,11 @@ package syncer
 
 import (
 	"errors"
+	"github.com/chain5j/chain5j-pkg/mclock"
 	"github.com/chain5j/chain5j-pkg/types"
 	"github.com/chain5j/chain5j-protocol/models"
 	"github.com/chain5j/chain5j-protocol/protocol"
+	"math/big"
 	"sync"
 )
 
@@ -24,8 +26,22 @@ type peer struct {
 
 	head        types.Hash
 	blockHeight uint64
+	weight      *big.Int       // 链权重，未知时为空
+	historyFrom uint64         // 可提供完整区块的最低高度
+	compression Compression    // 协商的压缩算法
+	wire        wireFormat     // 协商的编码
+	penalties   int            // 提供无效数据的次数
+	headSeen    mclock.AbsTime // 链头最近一次更新的时间
+	headProbed  mclock.AbsTime // 最近一次因链头过期请求handshake的时间
+	syncSource  bool           // 是否可作为同步来源，否则只对其提供数据
 	mu          sync.RWMutex
 
+	throughput peerThroughput // 吞吐量及往返时间估计
+	queue      *sendQueue     // 有界发送队列，由sendLoop发送
+
+	knownBlocks *lruCache // 对方已拥有的区块 hash==>struct{}
+	knownTxs    *lruCache // 对方已拥有的交易 hash==>struct{}
+
 	quitCh chan struct{}
 }
 
@@ -34,6 +50,10 @@ func newPeer(p2p protocol.P2PService, id models.P2PID) *peer {
 		P2PID:       id,
 		p2p:         p2p,
 		blockHeight: 0,
+		syncSource:  true,
+		knownBlocks: newLRUCache(maxKnownBlocks),
+		knownTxs:    newLRUCache(maxKnownTxs),
+		queue:       newSendQueue(),
 		quitCh:      make(chan struct{}),
 	}
 }
@@ -42,16 +62,31 @@ func (p *peer) close() {
 	close(p.quitCh)
 }
 
-func (p *peer) SetHead(hash types.Hash, height uint64) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+func (p *peer) SetHead(hash types.Hash, height uint64) bool {
+	return p.SetChainHead(ChainHead{Hash: hash, Height: height})
+}
 
-	if p.blockHeight > height {
-		return
+// SetChainHead 更新链头。双方都有链权重时按权重更新(权重更高的链可能更矮)，否则只往高处更新。
+// 未携带权重的更新保留已知的权重，直到有权重的更新替换。返回链头是否被接受
+func (p *peer) SetChainHead(head ChainHead) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := ChainHead{Height: p.blockHeight, Weight: p.weight}
+	if head.hasWeight() && current.hasWeight() {
+		if head.Weight.Cmp(current.Weight) < 0 {
+			return false
+		}
+	} else if p.blockHeight > head.Height {
+		return false
 	}
 
-	copy(p.head[:], hash[:])
-	p.blockHeight = height
+	if head.hasWeight() {
+		p.weight = new(big.Int).Set(head.Weight)
+	}
+	copy(p.head[:], head.Hash[:])
+	p.blockHeight = head.Height
+	return true
 }
 
 func (p *peer) Head() (hash types.Hash, height uint64) {
@@ -61,3 +96,65 @@ func (p *peer) Head() (hash types.Hash, height uint64) {
 	copy(hash[:], p.head[:])
 	return hash, p.blockHeight
 }
+
+// ChainHead 获取链头及链权重
+func (p *peer) ChainHead() ChainHead {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	head := ChainHead{Hash: p.head, Height: p.blockHeight}
+	if p.weight != nil {
+		head.Weight = new(big.Int).Set(p.weight)
+	}
+	return head
+}
+
+// SetHistoryFrom 更新节点可提供完整区块的最低高度
+func (p *peer) SetHistoryFrom(from uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.historyFrom = from
+}
+
+// HistoryFrom 节点可提供完整区块的最低高度
+func (p *peer) HistoryFrom() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.historyFrom
+}
+
+// SetCompression 设置状态交换时协商的压缩算法
+func (p *peer) SetCompression(compression Compression) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.compression = compression
+}
+
+// Compression 与该节点协商的压缩算法
+func (p *peer) Compression() Compression {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.compression
+}
+
+// SetWireFormat 设置状态交换时协商的编码
+func (p *peer) SetWireFormat(format wireFormat) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wire = format
+}
+
+// WireFormat 与该节点协商的编码
+func (p *peer) WireFormat() wireFormat {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.wire
+}
+
+// penalize 记录一次惩罚，返回累计次数
+func (p *peer) penalize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.penalties++
+	return p.penalties
+}
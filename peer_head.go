@@ -0,0 +1,83 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"time"
+)
+
+// markHeadSeen 记录链头更新的时间
+func (p *peer) markHeadSeen(now mclock.AbsTime) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.headSeen = now
+}
+
+// probeStale 链头超过timeout未更新，且超过timeout未请求过handshake时返回true，并记录本次请求
+func (p *peer) probeStale(now mclock.AbsTime, timeout time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Duration(now-p.headSeen) < timeout || time.Duration(now-p.headProbed) < timeout {
+		return false
+	}
+	p.headProbed = now
+	return true
+}
+
+// updatePeerHead 更新节点链头，被接受时记录更新时间。
+// handshake及区块通知不带权重，链头区块在本地已知时按配置的权重计算
+func (s *syncer) updatePeerHead(peer *peer, head ChainHead) {
+	if !head.hasWeight() && s.weigher != nil && head.Hash != (types.Hash{}) {
+		if header := s.localHeader(head.Hash); header != nil && header.Height == head.Height {
+			head.Weight = s.weigher.Weight(header)
+		}
+	}
+	if peer.SetChainHead(head) {
+		peer.markHeadSeen(s.clock.Now())
+	}
+}
+
+// updateHeadFromHeaders 由节点提供的header更新其链头，只使用与前一个header相连的部分
+func (s *syncer) updateHeadFromHeaders(peerId models.P2PID, headers []*models.Header) {
+	peer := s.peers.Peer(peerId)
+	if peer == nil || len(headers) == 0 {
+		return
+	}
+	highest := headers[0]
+	for i := 1; i < len(headers); i++ {
+		prev, next := headers[i-1], headers[i]
+		// 正向及反向的header都需相连
+		if next.ParentHash != prev.Hash() && prev.ParentHash != next.Hash() {
+			break
+		}
+		if next.Height > highest.Height {
+			highest = next
+		}
+	}
+	head := ChainHead{Hash: highest.Hash(), Height: highest.Height}
+	if s.weigher != nil {
+		head.Weight = s.weigher.Weight(highest)
+	}
+	s.updatePeerHead(peer, head)
+}
+
+// requestStaleHeads 只向链头过期的节点请求handshake
+func (s *syncer) requestStaleHeads() {
+	now := s.clock.Now()
+	for _, peer := range s.peers.Peers() {
+		if !peer.probeStale(now, s.staleHeadTimeout) {
+			continue
+		}
+		s.log.Debug("Request handshake from stale peer", "peer", peer.P2PID)
+		go func(peerId models.P2PID) {
+			if err := s.handshake.RequestHandshake(peerId); err != nil {
+				s.log.Debug("request handshake err", "peer", peerId, "err", err)
+			}
+		}(peer.P2PID)
+	}
+}
@@ -0,0 +1,40 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"github.com/chain5j/chain5j-protocol/models"
+	"testing"
+)
+
+func TestHeadersUpdatePeerHeadAfterChecks(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers func(headers []*models.Header) []*models.Header
+		commits map[uint64]error
+		height  uint64
+	}{
+		{name: "linked", headers: func(headers []*models.Header) []*models.Header { return headers }, height: 3},
+		{name: "unlinked", headers: func(headers []*models.Header) []*models.Header { return []*models.Header{headers[0], headers[2]} }},
+		{name: "invalid commit", headers: func(headers []*models.Header) []*models.Header { return headers }, commits: map[uint64]error{2: errors.New("bad signature")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain()
+			s, _ := newTestSyncer(t, chain, WithFinality(FinalityVerify, &testVerifier{commits: tt.commits}))
+			defer s.Stop()
+			drainCompleted(s)
+
+			const id = models.P2PID("remote")
+			peer := registerTestPeer(s, id, ChainHead{})
+			headers := tt.headers(testHeaders(chain.CurrentHeader(), 3, 0))
+			s.HandleBlockHeadersMsg(id, headers)
+
+			if head := peer.ChainHead(); head.Height != tt.height {
+				t.Fatalf("peer head = %d, want %d", head.Height, tt.height)
+			}
+		})
+	}
+}
@@ -9,14 +9,18 @@ import (
 )
 
 type peerSet struct {
-	peers  map[models.P2PID]*peer
-	lock   sync.RWMutex
-	closed bool
+	peers    map[models.P2PID]*peer
+	compare  HeadComparator // 链头比较器
+	selector PeerSelector   // 下载及按需获取时的节点选择策略
+	lock     sync.RWMutex
+	closed   bool
 }
 
 func newPeerSet() *peerSet {
 	return &peerSet{
-		peers: make(map[models.P2PID]*peer),
+		peers:    make(map[models.P2PID]*peer),
+		compare:  CompareByWeight,
+		selector: SelectByHead(CompareByWeight),
 	}
 }
 
@@ -69,29 +73,66 @@ func (ps *peerSet) IsExist(id models.P2PID) bool {
 	return false
 }
 
+// Close 关闭所有节点的发送协程，之后不再接受注册
 func (ps *peerSet) Close() {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 
-	for _, p := range ps.peers {
+	for id, p := range ps.peers {
 		p.close()
+		delete(ps.peers, id)
 	}
 	ps.closed = true
 }
 
-// 强制执行同步时，选择对方高度最高的进行同步
+// Peers 当前已注册的节点
+func (ps *peerSet) Peers() []*peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		list = append(list, p)
+	}
+	return list
+}
+
+// BestPeer 选择对方链头最优的节点，用于确定同步目标
 func (ps *peerSet) BestPeer() *peer {
-	ps.lock.Lock()
-	defer ps.lock.Unlock()
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
 
 	var (
-		bestPeer   *peer
-		bestHeight uint64
+		bestPeer *peer
+		bestHead ChainHead
 	)
 	for _, p := range ps.peers {
-		if _, height := p.Head(); bestPeer == nil || height > bestHeight {
-			bestPeer, bestHeight = p, height
+		if !p.SyncSource() {
+			continue
+		}
+		head := p.ChainHead()
+		if bestPeer == nil {
+			bestPeer, bestHead = p, head
+			continue
+		}
+		// 链头相同时按Id选择，结果与map的遍历顺序无关
+		if c := ps.compare(head, bestHead); c > 0 || (c == 0 && p.P2PID < bestPeer.P2PID) {
+			bestPeer, bestHead = p, head
 		}
 	}
 	return bestPeer
 }
+
+// BestPeerExcept 按选择策略选择高度不低于height的节点，跳过exclude中的节点
+func (ps *peerSet) BestPeerExcept(height uint64, exclude map[models.P2PID]bool) *peer {
+	return ps.selectPeer(height, exclude, func(p *peer, head ChainHead) bool {
+		return head.Height >= height
+	})
+}
+
+// BestPeerFor 按选择策略选择可提供[from,to]区间完整区块的节点，跳过exclude中的节点
+func (ps *peerSet) BestPeerFor(from, to uint64, exclude map[models.P2PID]bool) *peer {
+	return ps.selectPeer(to, exclude, func(p *peer, head ChainHead) bool {
+		return head.Height >= to && p.HistoryFrom() <= from
+	})
+}
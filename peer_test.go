@@ -0,0 +1,104 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/types"
+	"math/big"
+	"testing"
+)
+
+func TestPeerSetChainHead(t *testing.T) {
+	initial := ChainHead{Hash: types.Hash{1}, Height: 10, Weight: big.NewInt(100)}
+	tests := []struct {
+		name     string
+		head     ChainHead
+		accepted bool
+		weight   *big.Int
+	}{
+		{name: "heavier and lower", head: ChainHead{Hash: types.Hash{2}, Height: 8, Weight: big.NewInt(120)}, accepted: true, weight: big.NewInt(120)},
+		{name: "lighter and higher", head: ChainHead{Hash: types.Hash{2}, Height: 12, Weight: big.NewInt(90)}},
+		{name: "same head without weight", head: ChainHead{Hash: types.Hash{1}, Height: 10}, accepted: true, weight: big.NewInt(100)},
+		{name: "new head without weight", head: ChainHead{Hash: types.Hash{2}, Height: 11}, accepted: true, weight: big.NewInt(100)},
+		{name: "lower head without weight", head: ChainHead{Hash: types.Hash{2}, Height: 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPeer(nil, "peer")
+			p.SetChainHead(initial)
+
+			if accepted := p.SetChainHead(tt.head); accepted != tt.accepted {
+				t.Fatalf("accepted = %t, want %t", accepted, tt.accepted)
+			}
+			want := initial
+			if tt.accepted {
+				want = tt.head
+				want.Weight = tt.weight
+			}
+			got := p.ChainHead()
+			if got.Hash != want.Hash || got.Height != want.Height {
+				t.Fatalf("head = %d/%x, want %d/%x", got.Height, got.Hash, want.Height, want.Hash)
+			}
+			if (got.Weight == nil) != (want.Weight == nil) || got.Weight != nil && got.Weight.Cmp(want.Weight) != 0 {
+				t.Fatalf("weight = %v, want %v", got.Weight, want.Weight)
+			}
+		})
+	}
+}
+
+func TestPeerChainHeadMixedWeights(t *testing.T) {
+	// 依次应用的更新，未带权重的更新保留已知的权重
+	steps := []struct {
+		name     string
+		head     ChainHead
+		accepted bool
+		height   uint64
+		weight   int64
+	}{
+		{name: "status", head: ChainHead{Hash: types.Hash{1}, Height: 10, Weight: big.NewInt(100)}, accepted: true, height: 10, weight: 100},
+		{name: "announce", head: ChainHead{Hash: types.Hash{2}, Height: 11}, accepted: true, height: 11, weight: 100},
+		{name: "handshake", head: ChainHead{Hash: types.Hash{3}, Height: 12}, accepted: true, height: 12, weight: 100},
+		{name: "weighted status", head: ChainHead{Hash: types.Hash{3}, Height: 12, Weight: big.NewInt(130)}, accepted: true, height: 12, weight: 130},
+		{name: "lower announce", head: ChainHead{Hash: types.Hash{4}, Height: 9}, height: 12, weight: 130},
+		{name: "heavier reorg", head: ChainHead{Hash: types.Hash{5}, Height: 9, Weight: big.NewInt(150)}, accepted: true, height: 9, weight: 150},
+		{name: "lighter and higher", head: ChainHead{Hash: types.Hash{6}, Height: 13, Weight: big.NewInt(140)}, height: 9, weight: 150},
+	}
+	p := newPeer(nil, "peer")
+	for _, step := range steps {
+		if accepted := p.SetChainHead(step.head); accepted != step.accepted {
+			t.Fatalf("%s: accepted = %t, want %t", step.name, accepted, step.accepted)
+		}
+		head := p.ChainHead()
+		if head.Height != step.height || head.Weight == nil || head.Weight.Int64() != step.weight {
+			t.Fatalf("%s: head = %d/%v, want %d/%d", step.name, head.Height, head.Weight, step.height, step.weight)
+		}
+	}
+}
+
+func TestUpdatePeerHeadComputesWeight(t *testing.T) {
+	chain := newTestChain()
+	genesis := chain.GetHeaderByNumber(0)
+	fork := testHeaders(genesis, 3, 2)
+	local := testHeaders(genesis, 5, 0)
+	chain.extend(fork)
+	chain.extend(local)
+	s, _ := newTestSyncer(t, chain, WithChainWeigher(forkWeigher{}))
+	defer s.Stop()
+
+	peer := newPeer(s.p2p, "remote")
+	s.updatePeerHead(peer, ChainHead{Hash: local[4].Hash(), Height: 5})
+	if head := peer.ChainHead(); head.Weight == nil || head.Weight.Cmp(forkWeigher{}.Weight(local[4])) != 0 {
+		t.Fatalf("weight of known head = %v, want %v", head.Weight, forkWeigher{}.Weight(local[4]))
+	}
+	// 未知的更低链头无法比较权重，不被接受
+	s.updatePeerHead(peer, ChainHead{Hash: types.Hash{1}, Height: 3})
+	if head := peer.ChainHead(); head.Hash != local[4].Hash() {
+		t.Fatalf("unknown lower head accepted")
+	}
+	// 本地已知的更低但权重更高的分叉被接受
+	s.updatePeerHead(peer, ChainHead{Hash: fork[2].Hash(), Height: 3})
+	if head := peer.ChainHead(); head.Hash != fork[2].Hash() || head.Weight.Cmp(forkWeigher{}.Weight(fork[2])) != 0 {
+		t.Fatalf("head = %d/%v, want heavier fork %d/%v", head.Height, head.Weight, 3, forkWeigher{}.Weight(fork[2]))
+	}
+}
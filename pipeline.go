@@ -0,0 +1,351 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"sync"
+	"time"
+)
+
+const (
+	maxInflightPerPeer  = 4               // 单个节点同时进行的请求数
+	targetResponseTime  = 2 * time.Second // 单个请求期望的响应时间，请求大小按此计算
+	throughputImpact    = 0.1             // 新测量值对估计值的影响
+	initialHeaderFetch  = 64              // 未测量吞吐量时单次请求的header数
+	initialBodyFetch    = 16              // 未测量吞吐量时单次请求的body数
+	syncBatchRequests   = 4               // 同步流程中一批数据最多拆分的请求数(按MaxHeaderFetch计)
+	pipelineMaxFailures = 2               // 单次下载中节点失败该次数后不再使用
+	minFetchTimeout     = 3 * time.Second // 按往返时间计算的超时时间下限
+)
+
+// fetchKind 请求的数据类型
+type fetchKind int
+
+const (
+	headerFetch fetchKind = iota
+	bodyFetch
+)
+
+// peerThroughput 节点的吞吐量及往返时间估计
+type peerThroughput struct {
+	rates    [2]float64    // 每秒返回的数据个数，按fetchKind索引
+	rtt      time.Duration // 往返时间估计
+	inflight int           // 进行中的请求数
+	lock     sync.Mutex
+}
+
+// reserve 占用一个请求位，已达到上限时返回false
+func (t *peerThroughput) reserve() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.inflight >= maxInflightPerPeer {
+		return false
+	}
+	t.inflight++
+	return true
+}
+
+// idle 是否还有空闲的请求位
+func (t *peerThroughput) idle() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.inflight < maxInflightPerPeer
+}
+
+func (t *peerThroughput) release() {
+	t.lock.Lock()
+	t.inflight--
+	t.lock.Unlock()
+}
+
+// update 根据一次请求的结果更新估计值，items为0表示失败或超时
+func (t *peerThroughput) update(kind fetchKind, items int, elapsed time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if items == 0 {
+		t.rates[kind] /= 2
+		return
+	}
+	if elapsed <= 0 {
+		elapsed = time.Millisecond
+	}
+	measured := float64(items) / elapsed.Seconds()
+	if t.rates[kind] == 0 {
+		t.rates[kind] = measured
+	} else {
+		t.rates[kind] = (1-throughputImpact)*t.rates[kind] + throughputImpact*measured
+	}
+	if t.rtt == 0 {
+		t.rtt = elapsed
+	} else {
+		t.rtt = time.Duration((1-throughputImpact)*float64(t.rtt) + throughputImpact*float64(elapsed))
+	}
+}
+
+// snapshot 当前的吞吐量及往返时间估计
+func (t *peerThroughput) snapshot() ([2]float64, time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.rates, t.rtt
+}
+
+// timeout 按往返时间估计计算请求的超时时间
+func (t *peerThroughput) timeout() time.Duration {
+	t.lock.Lock()
+	rtt := t.rtt
+	t.lock.Unlock()
+
+	if rtt == 0 {
+		return p2pFetchTimeout
+	}
+	timeout := 3 * rtt
+	if timeout < minFetchTimeout {
+		timeout = minFetchTimeout
+	}
+	if timeout > p2pFetchTimeout {
+		timeout = p2pFetchTimeout
+	}
+	return timeout
+}
+
+// capacity 按目标响应时间计算单次请求的数据个数
+func (t *peerThroughput) capacity(kind fetchKind) int {
+	t.lock.Lock()
+	rate := t.rates[kind]
+	t.lock.Unlock()
+
+	limit, initial := MaxHeaderFetch, initialHeaderFetch
+	if kind == bodyFetch {
+		limit, initial = MaxBodyFetch, initialBodyFetch
+	}
+	if rate == 0 {
+		return initial
+	}
+	n := int(rate * targetResponseTime.Seconds())
+	if n < 1 {
+		n = 1
+	}
+	if n > limit {
+		n = limit
+	}
+	return n
+}
+
+// pipelineResult 单个请求的结果
+type pipelineResult struct {
+	peer    *peer
+	chunk   []int
+	filled  []int // 已获取到的索引
+	err     error
+	elapsed time.Duration
+}
+
+// pipeline 将total个数据拆分为多个请求，按节点容量确定请求大小，每个节点同时保持多个请求。
+// height返回索引对应的区块高度，未知时为0；eligible判断节点能否提供chunk，fetch发送请求并返回已获取到的索引
+func (p *p2pSource) pipeline(ctx context.Context, kind fetchKind, total int,
+	height func(index int) uint64,
+	eligible func(peer *peer, chunk []int) bool,
+	fetch func(ctx context.Context, peer *peer, chunk []int) ([]int, error)) error {
+	// 返回前需等待所有请求结束，避免结果在返回后仍被写入
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := make([][]int, 0, 1)
+	all := make([]int, total)
+	for i := range all {
+		all[i] = i
+	}
+	pending = append(pending, all)
+
+	var (
+		remaining = total
+		inflight  int
+		failures  = make(map[models.P2PID]int)
+		results   = make(chan *pipelineResult)
+		lastErr   error
+	)
+	for remaining > 0 {
+		// 尽可能多地分配请求
+		for len(pending) > 0 {
+			chunk := pending[0]
+			// 以chunk中最高的已知高度作为选择节点的目标高度
+			var target uint64
+			for _, index := range chunk {
+				if h := height(index); h > target {
+					target = h
+				}
+			}
+			worker := p.pickPeer(failures, target, func(peer *peer) bool { return eligible(peer, chunk) })
+			if worker == nil {
+				break
+			}
+			size := worker.throughput.capacity(kind)
+			if size < len(chunk) {
+				pending[0] = chunk[size:]
+				chunk = chunk[:size]
+			} else {
+				pending = pending[1:]
+			}
+			inflight++
+			wg.Add(1)
+			go func(peer *peer, chunk []int) {
+				defer wg.Done()
+				defer peer.throughput.release()
+				start := p.s.clock.Now()
+				filled, err := fetch(ctx, peer, chunk)
+				elapsed := time.Duration(p.s.clock.Now() - start)
+				select {
+				case results <- &pipelineResult{peer: peer, chunk: chunk, filled: filled, err: err, elapsed: elapsed}:
+				case <-ctx.Done():
+				}
+			}(worker, chunk)
+		}
+		if inflight == 0 {
+			if lastErr == nil {
+				lastErr = errNoPeers
+			}
+			return lastErr
+		}
+
+		var res *pipelineResult
+		select {
+		case res = <-results:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.s.quitCh:
+			return errStopped
+		}
+		inflight--
+		res.peer.throughput.update(kind, len(res.filled), res.elapsed)
+		remaining -= len(res.filled)
+
+		if len(res.filled) < len(res.chunk) {
+			if res.err == nil {
+				res.err = errNotAvailable
+			}
+			lastErr = res.err
+			failures[res.peer.P2PID]++
+			p.s.log.Debug("Pipelined fetch incomplete", "peer", res.peer.P2PID, "requested", len(res.chunk), "received", len(res.filled), "err", res.err)
+
+			// 未获取到的部分重新排队
+			done := make(map[int]bool, len(res.filled))
+			for _, index := range res.filled {
+				done[index] = true
+			}
+			var retry []int
+			for _, index := range res.chunk {
+				if !done[index] {
+					retry = append(retry, index)
+				}
+			}
+			pending = append([][]int{retry}, pending...)
+		}
+	}
+	return nil
+}
+
+// pickPeer 按选择策略从未达到请求上限的节点中选择，并占用一个请求位，target为请求需要的最低高度
+func (p *p2pSource) pickPeer(failures map[models.P2PID]int, target uint64, eligible func(peer *peer) bool) *peer {
+	exclude := make(map[models.P2PID]bool)
+	for id, n := range failures {
+		if n >= pipelineMaxFailures {
+			exclude[id] = true
+		}
+	}
+	for {
+		peer := p.s.peers.selectPeer(target, exclude, func(peer *peer, head ChainHead) bool {
+			return peer.throughput.idle() && eligible(peer)
+		})
+		if peer == nil {
+			return nil
+		}
+		if peer.throughput.reserve() {
+			return peer
+		}
+		// 选择后请求位已被占满，换其他节点
+		exclude[peer.P2PID] = true
+	}
+}
+
+// pipelineHeaders 从from开始流水线获取amount个header，返回连续的部分
+func (p *p2pSource) pipelineHeaders(ctx context.Context, from uint64, amount int) ([]*models.Header, error) {
+	headers := make([]*models.Header, amount)
+	err := p.pipeline(ctx, headerFetch, amount,
+		func(index int) uint64 { return from + uint64(index) },
+		func(peer *peer, chunk []int) bool {
+			// 与选择节点的目标高度相同，需能提供chunk中最高的header
+			first, last := from+uint64(chunk[0]), from+uint64(chunk[len(chunk)-1])
+			return peer.ChainHead().Height >= last && peer.HistoryFrom() <= first
+		},
+		func(ctx context.Context, peer *peer, chunk []int) ([]int, error) {
+			first := from + uint64(chunk[0])
+			list, err := p.fetchHeaders(ctx, peer, ext.HashOrNumber{Number: first}, len(chunk), false)
+			var filled []int
+			for i, header := range list {
+				if i >= len(chunk) || header == nil || header.Height != first+uint64(i) {
+					break
+				}
+				headers[chunk[i]] = header
+				filled = append(filled, chunk[i])
+			}
+			return filled, err
+		})
+
+	// 返回连续的部分，剩余部分由下一批获取
+	n := 0
+	for n < amount && headers[n] != nil {
+		n++
+	}
+	if n == 0 {
+		return nil, err
+	}
+	return headers[:n], nil
+}
+
+// pipelineBodies 流水线获取body，未获取到的为nil
+func (p *p2pSource) pipelineBodies(ctx context.Context, hashes []types.Hash, heights []uint64) ([]*models.Body, error) {
+	bodies := make([]*models.Body, len(hashes))
+	err := p.pipeline(ctx, bodyFetch, len(hashes),
+		func(index int) uint64 { return heights[index] },
+		func(peer *peer, chunk []int) bool {
+			head, from := peer.ChainHead().Height, peer.HistoryFrom()
+			for _, index := range chunk {
+				// 高度未知时只选择可提供完整历史的节点
+				if height := heights[index]; height == 0 && from > 0 || height != 0 && (height > head || height < from) {
+					return false
+				}
+			}
+			return true
+		},
+		func(ctx context.Context, peer *peer, chunk []int) ([]int, error) {
+			reqHashes := make([]types.Hash, len(chunk))
+			reqHeights := make([]uint64, len(chunk))
+			for i, index := range chunk {
+				reqHashes[i], reqHeights[i] = hashes[index], heights[index]
+			}
+			list, err := p.fetchBodies(ctx, peer, reqHashes, reqHeights)
+			var filled []int
+			for i, body := range list {
+				if i < len(chunk) && body != nil {
+					bodies[chunk[i]] = body
+					filled = append(filled, chunk[i])
+				}
+			}
+			return filled, err
+		})
+	for _, body := range bodies {
+		if body != nil {
+			return bodies, nil
+		}
+	}
+	return nil, err
+}
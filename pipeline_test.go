@@ -0,0 +1,135 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"math"
+	"testing"
+	"time"
+)
+
+// throughputUpdate 一次请求的结果
+type throughputUpdate struct {
+	kind    fetchKind
+	items   int
+	elapsed time.Duration
+}
+
+func TestPeerThroughputUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []throughputUpdate
+		rates   [2]float64
+		rtt     time.Duration
+	}{
+		{name: "first measurement", updates: []throughputUpdate{{headerFetch, 100, time.Second}}, rates: [2]float64{100, 0}, rtt: time.Second},
+		{
+			name:    "moving average",
+			updates: []throughputUpdate{{headerFetch, 100, time.Second}, {headerFetch, 400, 2 * time.Second}},
+			rates:   [2]float64{110, 0},
+			rtt:     1100 * time.Millisecond,
+		},
+		{
+			name:    "failure halves rate",
+			updates: []throughputUpdate{{bodyFetch, 100, time.Second}, {bodyFetch, 0, p2pFetchTimeout}},
+			rates:   [2]float64{0, 50},
+			rtt:     time.Second,
+		},
+		{name: "zero elapsed", updates: []throughputUpdate{{headerFetch, 1, 0}}, rates: [2]float64{1000, 0}, rtt: time.Millisecond},
+		{
+			name:    "kinds are independent",
+			updates: []throughputUpdate{{headerFetch, 100, time.Second}, {bodyFetch, 10, time.Second}},
+			rates:   [2]float64{100, 10},
+			rtt:     time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var throughput peerThroughput
+			for _, update := range tt.updates {
+				throughput.update(update.kind, update.items, update.elapsed)
+			}
+			rates, rtt := throughput.snapshot()
+			for kind := range rates {
+				if math.Abs(rates[kind]-tt.rates[kind]) > 1e-9 {
+					t.Fatalf("rates = %v, want %v", rates, tt.rates)
+				}
+			}
+			if rtt != tt.rtt {
+				t.Fatalf("rtt = %s, want %s", rtt, tt.rtt)
+			}
+		})
+	}
+}
+
+func TestPeerThroughputCapacity(t *testing.T) {
+	tests := []struct {
+		name string
+		kind fetchKind
+		rate float64
+		want int
+	}{
+		{name: "headers unmeasured", kind: headerFetch, want: initialHeaderFetch},
+		{name: "bodies unmeasured", kind: bodyFetch, want: initialBodyFetch},
+		{name: "at least one", kind: headerFetch, rate: 0.1, want: 1},
+		{name: "target response time", kind: headerFetch, rate: 10, want: 20},
+		{name: "headers clamped", kind: headerFetch, rate: 1000, want: MaxHeaderFetch},
+		{name: "bodies clamped", kind: bodyFetch, rate: 1000, want: MaxBodyFetch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var throughput peerThroughput
+			throughput.rates[tt.kind] = tt.rate
+			if got := throughput.capacity(tt.kind); got != tt.want {
+				t.Fatalf("capacity = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerThroughputTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		rtt  time.Duration
+		want time.Duration
+	}{
+		{name: "unmeasured", want: p2pFetchTimeout},
+		{name: "lower bound", rtt: 100 * time.Millisecond, want: minFetchTimeout},
+		{name: "three rtt", rtt: 2 * time.Second, want: 6 * time.Second},
+		{name: "upper bound", rtt: time.Minute, want: p2pFetchTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throughput := peerThroughput{rtt: tt.rtt}
+			if got := throughput.timeout(); got != tt.want {
+				t.Fatalf("timeout = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipelineHeadersChecksChunkHeight(t *testing.T) {
+	// 优先选择的节点链头低于请求范围的最高高度，不能提供该请求
+	s, _ := newTestSyncer(t, newTestChain(), WithPeerSelector(SelectPreferred([]models.P2PID{"a-short"}, nil)))
+	defer s.Stop()
+
+	var peers []*peer
+	for id, height := range map[models.P2PID]uint64{"a-short": 20, "b-full": 200} {
+		p := newPeer(s.p2p, id)
+		p.SetChainHead(ChainHead{Hash: types.Hash{byte(height)}, Height: height})
+		s.peers.Register(p, nil)
+		peers = append(peers, p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	(&p2pSource{s: s}).pipelineHeaders(ctx, 10, 32)
+
+	if got := requestedPeers(peers, GetBlockHeadersMsg); len(got) != 1 || got[0] != "b-full" {
+		t.Fatalf("requested from %v, want only b-full", got)
+	}
+}
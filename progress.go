@@ -0,0 +1,104 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-pkg/util/hexutil"
+	"github.com/chain5j/chain5j-protocol/models"
+	"math/big"
+	"sync"
+)
+
+// SyncProgress 同步进度
+type SyncProgress struct {
+	Mode          string `json:"mode"`           // 同步模式
+	Source        string `json:"source"`         // 当前的同步来源(节点ID或archive)
+	StartingBlock uint64 `json:"starting_block"` // 本轮同步开始时的高度
+	CurrentBlock  uint64 `json:"current_block"`  // 当前高度
+	HighestBlock  uint64 `json:"highest_block"`  // 本轮同步的目标高度
+	Imported      uint64 `json:"imported"`       // 累计导入的区块数
+	Failed        uint64 `json:"failed"`         // 累计处理失败的区块数
+
+	State      string     `json:"state"`                 // 同步状态
+	Target     uint64     `json:"target,omitempty"`      // 指定的目标高度
+	TargetHash types.Hash `json:"target_hash,omitempty"` // 指定的目标hash
+}
+
+// Syncing 是否在同步中
+func (p SyncProgress) Syncing() bool {
+	return p.CurrentBlock < p.HighestBlock
+}
+
+// progress 同步进度的记录
+type progress struct {
+	source   string
+	starting uint64
+	highest  uint64
+	imported uint64
+	failed   uint64
+	lock     sync.RWMutex
+}
+
+// begin 开始新一轮同步。如果上一轮还未完成，只提高目标高度
+func (p *progress) begin(source string, current, highest uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if current >= p.highest {
+		p.starting = current
+	}
+	if highest > p.highest || current >= p.highest {
+		p.highest = highest
+	}
+	p.source = source
+}
+
+func (p *progress) addImported() {
+	p.lock.Lock()
+	p.imported++
+	p.lock.Unlock()
+}
+
+func (p *progress) addFailed() {
+	p.lock.Lock()
+	p.failed++
+	p.lock.Unlock()
+}
+
+// Progress 获取同步进度
+func (s *syncer) Progress() SyncProgress {
+	s.progress.lock.RLock()
+	defer s.progress.lock.RUnlock()
+
+	current := s.localHeight()
+	highest := s.capTarget(s.progress.highest)
+	if highest < current {
+		highest = current
+	}
+	state, target, targetHash := s.syncState(current, highest)
+	return SyncProgress{
+		Mode:          s.mode.String(),
+		Source:        s.progress.source,
+		StartingBlock: s.progress.starting,
+		CurrentBlock:  current,
+		HighestBlock:  highest,
+		Imported:      s.progress.imported,
+		Failed:        s.progress.failed,
+		State:         state,
+		Target:        target,
+		TargetHash:    targetHash,
+	}
+}
+
+// SyncingStatus 获取同步状态
+func (s *syncer) SyncingStatus() *models.SyncingStatus {
+	p := s.Progress()
+	return &models.SyncingStatus{
+		IsSync:        p.Syncing(),
+		StartingBlock: (*hexutil.Big)(new(big.Int).SetUint64(p.StartingBlock)),
+		CurrentBlock:  (*hexutil.Big)(new(big.Int).SetUint64(p.CurrentBlock)),
+		HighestBlock:  (*hexutil.Big)(new(big.Int).SetUint64(p.HighestBlock)),
+	}
+}
@@ -0,0 +1,208 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/event"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/eventtype"
+	"sync"
+)
+
+const maxReorgDepth = 1024 // 查找公共祖先时最多回溯的区块数
+
+var (
+	errUnknownAncestor = errors.New("unknown reorg ancestor")
+	errReorgTooDeep    = errors.New("reorg too deep")
+)
+
+// ReorgEvent 本节点切换到其他分叉的事件
+type ReorgEvent struct {
+	Ancestor *models.Header  // 新旧链的公共祖先
+	Dropped  []*models.Block // 被回滚的区块，按高度升序
+	Added    []*models.Block // 新链上的区块，按高度升序
+}
+
+// SubscribeReorg 订阅切换分叉的事件
+func (s *syncer) SubscribeReorg(ch chan<- *ReorgEvent) event.Subscription {
+	return s.reorgScope.Track(s.reorgFeed.Subscribe(ch))
+}
+
+// chainHeadLoop 监听链头，切换分叉时清空服务端缓存、放回被回滚的交易并发布reorg事件
+func (s *syncer) chainHeadLoop() {
+	chainHeadCh := make(chan eventtype.ChainHeadEvent, 16)
+	chainHeadSub := s.blockRW.SubscribeChainHeadEvent(chainHeadCh)
+	defer chainHeadSub.Unsubscribe()
+
+	head := s.blockRW.CurrentBlock()
+	for {
+		select {
+		case ev := <-chainHeadCh:
+			if ev.Block == nil {
+				continue
+			}
+			if head != nil && head.Hash() != ev.Block.ParentHash() {
+				s.headReplaced(head, ev.Block)
+			}
+			head = ev.Block
+		case err := <-chainHeadSub.Err():
+			s.log.Error("chainHeadSub", "err", err)
+			return
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// headReplaced 新链头不是在原链头上延伸，查找公共祖先判断是否发生了reorg
+func (s *syncer) headReplaced(oldHead, newHead *models.Block) {
+	ev, err := s.findReorg(oldHead, newHead)
+	if err != nil {
+		// 无法确定时按reorg处理缓存
+		s.log.Warn("find reorg err", "old", oldHead.Hash(), "new", newHead.Hash(), "err", err)
+		s.serveCache.purge()
+		return
+	}
+	// 新链头包含原链头，只是跳过了中间的链头事件
+	if len(ev.Dropped) == 0 {
+		return
+	}
+	s.serveCache.purge()
+	s.log.Info("Chain reorg", "ancestor", ev.Ancestor.Height, "dropped", len(ev.Dropped), "added", len(ev.Added), "head", newHead.Height())
+	s.fallbackTxs(ev)
+	s.reorgs.push(ev)
+}
+
+// reorgQueue 待发布的reorg事件。由reorgLoop按发生顺序发布，订阅方处理慢时不阻塞链头监听
+type reorgQueue struct {
+	pending []*ReorgEvent
+	wake    chan struct{}
+	lock    sync.Mutex
+}
+
+func newReorgQueue() *reorgQueue {
+	return &reorgQueue{wake: make(chan struct{}, 1)}
+}
+
+func (q *reorgQueue) push(ev *ReorgEvent) {
+	q.lock.Lock()
+	q.pending = append(q.pending, ev)
+	q.lock.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take 取出全部待发布的事件
+func (q *reorgQueue) take() []*ReorgEvent {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
+
+// reorgLoop 按发生顺序发布reorg事件，停止后订阅被关闭，未发布的事件被丢弃
+func (s *syncer) reorgLoop() {
+	for {
+		for _, ev := range s.reorgs.take() {
+			s.reorgFeed.Send(ev)
+		}
+		select {
+		case <-s.reorgs.wake:
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// findReorg 从新旧链头回溯到公共祖先
+func (s *syncer) findReorg(oldHead, newHead *models.Block) (*ReorgEvent, error) {
+	var (
+		dropped, added []*models.Block
+		old, cur       = oldHead, newHead
+		err            error
+	)
+	for old.Hash() != cur.Hash() {
+		if len(dropped)+len(added) > maxReorgDepth {
+			return nil, fmt.Errorf("%w: depth>%d", errReorgTooDeep, maxReorgDepth)
+		}
+		// 先回溯较高的一侧，高度相同时两侧同时回溯
+		oldHeight, curHeight := old.Height(), cur.Height()
+		if oldHeight >= curHeight {
+			dropped = append(dropped, old)
+			if old, err = s.parentBlock(old); err != nil {
+				return nil, err
+			}
+		}
+		if curHeight >= oldHeight {
+			added = append(added, cur)
+			if cur, err = s.parentBlock(cur); err != nil {
+				return nil, err
+			}
+		}
+	}
+	reverseBlocks(dropped)
+	reverseBlocks(added)
+	return &ReorgEvent{Ancestor: cur.Header(), Dropped: dropped, Added: added}, nil
+}
+
+func (s *syncer) parentBlock(block *models.Block) (*models.Block, error) {
+	if block.Height() == 0 {
+		return nil, fmt.Errorf("%w: different genesis %s", errUnknownAncestor, block.Hash().Hex())
+	}
+	parent := s.blockRW.GetBlock(block.ParentHash(), block.Height()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("%w: height=%d hash=%s", errUnknownAncestor, block.Height()-1, block.ParentHash().Hex())
+	}
+	return parent, nil
+}
+
+func reverseBlocks(blocks []*models.Block) {
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+}
+
+// fallbackTxs 将被回滚区块中、未包含在新链上的交易放回交易池
+func (s *syncer) fallbackTxs(ev *ReorgEvent) {
+	included := make(map[types.Hash]struct{})
+	for _, block := range ev.Added {
+		for _, list := range block.Transactions() {
+			for _, tx := range list {
+				included[tx.Hash()] = struct{}{}
+			}
+		}
+	}
+	reverted := make(map[types.TxType][]models.Transaction)
+	for _, block := range ev.Dropped {
+		for _, list := range block.Transactions() {
+			for _, tx := range list {
+				if _, ok := included[tx.Hash()]; !ok {
+					reverted[tx.TxType()] = append(reverted[tx.TxType()], tx)
+				}
+			}
+		}
+	}
+	if s.txPools == nil {
+		var count int
+		for _, txs := range reverted {
+			count += len(txs)
+		}
+		if count > 0 {
+			s.log.Warn("Reverted txs dropped without tx pools", "ancestor", ev.Ancestor.Height, "count", count)
+		}
+		return
+	}
+	for txType, txs := range reverted {
+		if err := s.txPools.Fallback(txType, txs); err != nil {
+			s.log.Warn("fallback reverted txs err", "txType", txType, "count", len(txs), "err", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-protocol/models"
+	"testing"
+	"time"
+)
+
+func TestReorgEventsPublishedInOrder(t *testing.T) {
+	chain := newTestChain()
+	genesis := chain.GetHeaderByNumber(0)
+	forkB := chain.extend(testHeaders(genesis, 2, 2))
+	forkA := chain.extend(testHeaders(genesis, 2, 1))
+	s, _ := newTestSyncer(t, chain)
+	if err := s.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer s.Stop()
+
+	// 订阅方尚未读取时，切换分叉不阻塞
+	ch := make(chan *ReorgEvent)
+	sub := s.SubscribeReorg(ch)
+	defer sub.Unsubscribe()
+	s.headReplaced(forkA[1], forkB[1])
+	s.headReplaced(forkB[1], forkA[1])
+
+	wants := []struct{ dropped, added *models.Block }{
+		{dropped: forkA[0], added: forkB[0]},
+		{dropped: forkB[0], added: forkA[0]},
+	}
+	for i, want := range wants {
+		select {
+		case ev := <-ch:
+			if len(ev.Dropped) != 2 || len(ev.Added) != 2 || ev.Ancestor.Height != 0 {
+				t.Fatalf("reorg %d: dropped %d added %d ancestor %d", i, len(ev.Dropped), len(ev.Added), ev.Ancestor.Height)
+			}
+			if ev.Dropped[0].Hash() != want.dropped.Hash() || ev.Added[0].Hash() != want.added.Hash() {
+				t.Fatalf("reorg %d published out of order", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("reorg %d not published", i)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/event"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-protocol/models"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	replaySubscribeTimeout = 5 * time.Second // 等待syncer完成订阅的超时时间
+	replayStartTimers      = 2               // syncBlocks启动时设置的强制同步及链头检查定时器
+	replayStepBuffer       = 1024
+)
+
+var errReplaySubscribe = errors.New("syncer did not subscribe in time")
+
+// replayStep syncer已处理完成的事件
+type replayStep struct {
+	kind uint8
+	code uint64
+}
+
+// replayed 回放时通知事件已处理完成
+func (s *syncer) replayed(kind uint8, code uint64) {
+	if s.replaySteps == nil {
+		return
+	}
+	select {
+	case s.replaySteps <- replayStep{kind: kind, code: code}:
+	case <-s.quitCh:
+	}
+}
+
+// replayP2P 回放使用的p2p服务，入站消息来自追踪文件，发送的消息被记录下来
+type replayP2P struct {
+	clock mclock.Clock
+	start mclock.AbsTime
+
+	msgFeeds map[uint]*event.Feed
+	subs     map[uint]int  // 各消息类型的订阅数
+	subCh    chan struct{} // 有新的订阅时通知
+	dropFeed event.Feed
+	drops    int
+	sent     []*TraceEvent
+	lock     sync.Mutex
+}
+
+func newReplayP2P(clock mclock.Clock) *replayP2P {
+	return &replayP2P{
+		clock:    clock,
+		start:    clock.Now(),
+		msgFeeds: make(map[uint]*event.Feed),
+		subs:     make(map[uint]int),
+		subCh:    make(chan struct{}, 1),
+	}
+}
+
+// notifySubscribed 通知有新的订阅，调用时需持有锁
+func (p *replayP2P) notifySubscribed() {
+	select {
+	case p.subCh <- struct{}{}:
+	default:
+	}
+}
+
+func (p *replayP2P) Start() error                         { return nil }
+func (p *replayP2P) Stop() error                          { return nil }
+func (p *replayP2P) Id() models.P2PID                     { return "replay" }
+func (p *replayP2P) NetURL() string                       { return "" }
+func (p *replayP2P) RemotePeers() []models.P2PID          { return nil }
+func (p *replayP2P) P2PInfo() map[string]*models.P2PInfo  { return nil }
+func (p *replayP2P) HandshakeSuccess(peerId models.P2PID) {}
+func (p *replayP2P) AddPeer(peerUrl string) error         { return nil }
+func (p *replayP2P) DropPeer(peerId models.P2PID) error   { return nil } // 断开事件由追踪文件送入
+func (p *replayP2P) Send(peerId models.P2PID, msg *models.P2PMessage) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.sent = append(p.sent, &TraceEvent{
+		Time: uint64(p.clock.Now() - p.start),
+		Kind: TraceOutbound,
+		Code: uint64(msg.Type),
+		Peer: peerId,
+		Data: msg.Data,
+	})
+	return nil
+}
+
+func (p *replayP2P) feed(msgType uint) *event.Feed {
+	feed, ok := p.msgFeeds[msgType]
+	if !ok {
+		feed = new(event.Feed)
+		p.msgFeeds[msgType] = feed
+	}
+	return feed
+}
+
+func (p *replayP2P) SubscribeMsg(msgType uint, ch chan<- *models.P2PMessage) event.Subscription {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.subs[msgType]++
+	p.notifySubscribed()
+	return p.feed(msgType).Subscribe(ch)
+}
+
+func (p *replayP2P) SubscribeHandshakePeer(ch chan<- models.P2PID) event.Subscription {
+	return new(event.Feed).Subscribe(ch)
+}
+
+func (p *replayP2P) SubscribeNewPeer(ch chan<- models.P2PID) event.Subscription {
+	return new(event.Feed).Subscribe(ch)
+}
+
+func (p *replayP2P) SubscribeDropPeer(ch chan<- models.P2PID) event.Subscription {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.drops++
+	p.notifySubscribed()
+	return p.dropFeed.Subscribe(ch)
+}
+
+func (p *replayP2P) deliver(msg *models.P2PMessage) {
+	p.lock.Lock()
+	feed := p.feed(msg.Type)
+	p.lock.Unlock()
+	feed.Send(msg)
+}
+
+func (p *replayP2P) subscribed(codes map[uint]bool) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.drops == 0 {
+		return false
+	}
+	for code := range codes {
+		if p.subs[code] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *replayP2P) sentEvents() []*TraceEvent {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*TraceEvent(nil), p.sent...)
+}
+
+// replayHandshake 回放使用的handshake，事件来自追踪文件
+type replayHandshake struct {
+	feed  event.Feed
+	subs  int
+	subCh chan struct{} // 订阅时通知，与replayP2P共用
+	lock  sync.Mutex
+}
+
+func (h *replayHandshake) Start() error                           { return nil }
+func (h *replayHandshake) Stop() error                            { return nil }
+func (h *replayHandshake) RequestHandshake(id models.P2PID) error { return nil }
+func (h *replayHandshake) SubscribeHandshake(ch chan *models.HandshakeMsg) event.Subscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.subs++
+	if h.subCh != nil {
+		select {
+		case h.subCh <- struct{}{}:
+		default:
+		}
+	}
+	return h.feed.Subscribe(ch)
+}
+
+func (h *replayHandshake) subscribed() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.subs > 0
+}
+
+// ReplayTrace 使用模拟的p2p服务及时钟回放追踪文件，返回回放过程中syncer发送的消息。
+// 按记录的时间推进模拟时钟，依次送入入站消息、handshake及断开事件，定时器由模拟时钟触发。
+// 每个事件送入后等待syncer处理完成再送入下一个，处理过程中的消息同步发送；
+// 同步下载在独立的协程中进行，其发送的消息不保证与记录的顺序一致。
+// blockRW等其他依赖通过opts传入，p2p、handshake及时钟会被替换
+func ReplayTrace(ctx context.Context, r io.Reader, opts ...option) ([]*TraceEvent, error) {
+	events, err := ReadTrace(r)
+	if err != nil {
+		return nil, err
+	}
+	clock := new(mclock.Simulated)
+	p2p := newReplayP2P(clock)
+	handshake := &replayHandshake{subCh: p2p.subCh}
+
+	opts = append(opts, WithP2PService(p2p), WithHandshake(handshake), WithClock(clock))
+	api, err := NewSyncer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := api.(*syncer)
+	s.replaySteps = make(chan replayStep, replayStepBuffer)
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	defer s.Stop()
+
+	// 等待syncer完成对追踪中各消息类型的订阅，并设置启动时的定时器
+	codes := make(map[uint]bool)
+	for _, ev := range events {
+		if ev.Kind == TraceInbound {
+			codes[uint(ev.Code)] = true
+		}
+	}
+	if err := waitReplaySubscribed(ctx, p2p, handshake, codes); err != nil {
+		return nil, err
+	}
+	clock.WaitForTimers(replayStartTimers)
+
+	var (
+		now     time.Duration
+		handled []replayStep // 已完成但尚未对应到追踪事件的处理，同一时间的事件可能先后完成
+	)
+	for i, ev := range events {
+		if elapsed := ev.traceElapsed(); elapsed > now {
+			clock.Run(elapsed - now)
+			now = elapsed
+		}
+		switch ev.Kind {
+		case TraceInbound:
+			p2p.deliver(&models.P2PMessage{Type: uint(ev.Code), Peer: ev.Peer, Data: ev.Data})
+		case TraceHandshake:
+			msg := new(models.HandshakeMsg)
+			if err := codec.Coder().Decode(ev.Data, msg); err != nil {
+				return nil, fmt.Errorf("%w: event %d: %v", errInvalidTrace, i, err)
+			}
+			msg.Peer = ev.Peer
+			handshake.feed.Send(msg)
+		case TraceDrop:
+			p2p.dropFeed.Send(ev.Peer)
+		case TraceTimer:
+			// 定时器已在推进时钟时触发
+		default:
+			continue
+		}
+		if err := waitReplayStep(ctx, s, ev, &handled); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+	return p2p.sentEvents(), nil
+}
+
+// waitReplaySubscribed 等待syncer完成消息、断开及handshake事件的订阅
+func waitReplaySubscribed(ctx context.Context, p2p *replayP2P, handshake *replayHandshake, codes map[uint]bool) error {
+	timeout := time.NewTimer(replaySubscribeTimeout)
+	defer timeout.Stop()
+
+	for !p2p.subscribed(codes) || !handshake.subscribed() {
+		select {
+		case <-p2p.subCh:
+		case <-timeout.C:
+			return errReplaySubscribe
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// waitReplayStep 等待syncer处理完成事件ev，其间完成的其他事件记录在handled中，供之后的事件对应
+func waitReplayStep(ctx context.Context, s *syncer, ev *TraceEvent, handled *[]replayStep) error {
+	want := replayStep{kind: ev.Kind, code: ev.Code}
+	for i, step := range *handled {
+		if step == want {
+			*handled = append((*handled)[:i], (*handled)[i+1:]...)
+			return nil
+		}
+	}
+	for {
+		select {
+		case step := <-s.replaySteps:
+			if step == want {
+				return nil
+			}
+			*handled = append(*handled, step)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitSent 等待p2p发送n条消息
+func waitSent(t *testing.T, p2p *replayP2P, n int) []*TraceEvent {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if sent := p2p.sentEvents(); len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %d messages, want %d", len(p2p.sentEvents()), n)
+		}
+	}
+}
+
+// outbound 发送的消息，不含发送时间
+func outbound(events []*TraceEvent) []TraceEvent {
+	var list []TraceEvent
+	for _, ev := range events {
+		if ev.Kind == TraceOutbound {
+			list = append(list, TraceEvent{Kind: ev.Kind, Code: ev.Code, Peer: ev.Peer, Data: ev.Data})
+		}
+	}
+	return list
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	headers := testHeaders(newTestChain().GetHeaderByNumber(0), 3, 0)
+	chain := newTestChain()
+	chain.extend(headers)
+
+	var trace bytes.Buffer
+	s, p2p := newTestSyncer(t, chain, WithTraceRecorder(&trace))
+	if err := s.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitSubscribed(t, s, p2p)
+	clock := s.clock.(*mclock.Simulated)
+	clock.WaitForTimers(replayStartTimers)
+
+	// 逐个送入事件，每个事件的响应发出后再送入下一个
+	handshake := s.handshake.(*tracingHandshake).Handshake.(*replayHandshake)
+	handshake.feed.Send(&models.HandshakeMsg{Peer: "handshake", CurrentBlockHeight: 3})
+	waitSent(t, p2p, 1)
+
+	request, err := codec.Coder().Encode(&ext.GetBlockHeadersData{Origin: ext.HashOrNumber{Number: 1}, Amount: 2})
+	if err != nil {
+		t.Fatalf("encode request: %v", err)
+	}
+	p2p.deliver(&models.P2PMessage{Type: GetBlockHeadersMsg, Peer: "remote", Data: request})
+	waitSent(t, p2p, 2)
+
+	clock.Run(forceSyncCycle)
+	request, err = codec.Coder().Encode([]types.Hash{headers[0].Hash(), headers[2].Hash()})
+	if err != nil {
+		t.Fatalf("encode request: %v", err)
+	}
+	p2p.deliver(&models.P2PMessage{Type: GetBlockBodiesMsg, Peer: "remote", Data: request})
+	recorded := outbound(waitSent(t, p2p, 3))
+	s.Stop()
+
+	events, err := ReadTrace(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatalf("read trace: %v", err)
+	}
+	kinds := make(map[uint8]int)
+	for _, ev := range events {
+		kinds[ev.Kind]++
+	}
+	if kinds[TraceHandshake] != 1 || kinds[TraceInbound] != 2 || kinds[TraceTimer] != 1 || kinds[TraceOutbound] != 3 {
+		t.Fatalf("recorded events = %v", kinds)
+	}
+
+	// 回放使用相同的链，发送的消息与记录的一致
+	replayChain := newTestChain()
+	replayChain.extend(headers)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sent, err := ReplayTrace(ctx, bytes.NewReader(trace.Bytes()), WithBlockRW(replayChain))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if replayed := outbound(sent); !reflect.DeepEqual(replayed, recorded) {
+		t.Fatalf("replayed %d messages, want %d:\n%+v\n%+v", len(replayed), len(recorded), replayed, recorded)
+	}
+}
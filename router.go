@@ -0,0 +1,310 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/event"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-protocol/models"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateClass 消息的限流类别
+type rateClass int
+
+const (
+	rateUnlimited rateClass = iota // 不限流，如状态交换及本节点请求的响应
+	rateRequest                    // 对方的数据请求，消耗本地的读取
+	rateGossip                     // 区块通知及交易广播
+	numRateClasses
+)
+
+// rateLimits 各类别每个节点每秒的消息数及突发上限
+var rateLimits = [numRateClasses]struct {
+	rate  float64
+	burst float64
+}{
+	rateRequest: {rate: 20, burst: 40},
+	rateGossip:  {rate: 50, burst: 200},
+}
+
+var (
+	errUnknownMsg       = errors.New("unknown message type")
+	errDuplicateHandler = errors.New("duplicate message handler")
+	errMsgTooLarge      = errors.New("message too large")
+	errTooManyItems     = errors.New("too many items in message")
+	errInvalidMsg       = errors.New("invalid message")
+	errRateLimited      = errors.New("message rate limited")
+	errHandlerPanic     = errors.New("message handler panic")
+)
+
+// msgHandler 一类消息的声明，由msgRouter统一订阅、解码、限流及统计
+type msgHandler struct {
+	code       uint64
+	name       string
+	maxSize    int       // 最大消息大小，可压缩的消息按解压后计算
+	maxItems   int       // 最大元素个数，0为不限制
+	rate       rateClass // 限流类别
+	compressed bool      // 是否按与对方协商的算法压缩
+	fixedCodec bool      // 始终使用不带版本号的RLP，用于协商之前的状态交换
+
+	newMsg func() interface{}                                          // 返回解码目标的指针
+	items  func(msg interface{}) (int, error)                          // 校验解码结果并返回元素个数，可为空
+	handle func(s *syncer, peerId models.P2PID, msg interface{}) error // 处理解码后的消息
+}
+
+// MsgStats 一类入站消息的统计
+type MsgStats struct {
+	Code     uint64 `json:"code"`
+	Name     string `json:"name"`
+	Received uint64 `json:"received"` // 接收的消息数
+	Bytes    uint64 `json:"bytes"`    // 接收的字节数
+	Invalid  uint64 `json:"invalid"`  // 超过限制或解码失败
+	Limited  uint64 `json:"limited"`  // 超过限流被丢弃
+	Failed   uint64 `json:"failed"`   // 处理失败
+	Panics   uint64 `json:"panics"`   // 处理中发生panic
+}
+
+// tokenBucket 单个节点单个类别的令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   mclock.AbsTime
+}
+
+// msgRouter 消息注册表及分发器
+type msgRouter struct {
+	handlers map[uint64]*msgHandler
+	codes    []uint64 // 按注册顺序
+	stats    map[uint64]*MsgStats
+
+	buckets map[models.P2PID]*[numRateClasses]tokenBucket
+	lock    sync.Mutex
+}
+
+func newMsgRouter() *msgRouter {
+	return &msgRouter{
+		handlers: make(map[uint64]*msgHandler),
+		stats:    make(map[uint64]*MsgStats),
+		buckets:  make(map[models.P2PID]*[numRateClasses]tokenBucket),
+	}
+}
+
+// register 注册消息处理，需在Start之前完成
+func (r *msgRouter) register(handlers ...*msgHandler) error {
+	for _, h := range handlers {
+		if _, ok := r.handlers[h.code]; ok {
+			return fmt.Errorf("%w: %s(%d)", errDuplicateHandler, h.name, h.code)
+		}
+		r.handlers[h.code] = h
+		r.codes = append(r.codes, h.code)
+		r.stats[h.code] = &MsgStats{Code: h.code, Name: h.name}
+	}
+	return nil
+}
+
+// allow 按令牌桶判断节点的消息是否超过限流
+func (r *msgRouter) allow(peerId models.P2PID, class rateClass, now mclock.AbsTime) bool {
+	if class == rateUnlimited {
+		return true
+	}
+	limit := rateLimits[class]
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// 新节点的令牌桶都是满的，不能以时间为0判断，模拟时钟从0开始
+	buckets, ok := r.buckets[peerId]
+	if !ok {
+		buckets = new([numRateClasses]tokenBucket)
+		for i := range buckets {
+			buckets[i] = tokenBucket{tokens: rateLimits[i].burst, last: now}
+		}
+		r.buckets[peerId] = buckets
+	}
+	bucket := &buckets[class]
+	bucket.tokens += time.Duration(now-bucket.last).Seconds() * limit.rate
+	if bucket.tokens > limit.burst {
+		bucket.tokens = limit.burst
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// forget 节点断开后清除其限流状态
+func (r *msgRouter) forget(peerId models.P2PID) {
+	r.lock.Lock()
+	delete(r.buckets, peerId)
+	r.lock.Unlock()
+}
+
+// MessageStats 各类入站消息的统计，按注册顺序
+func (s *syncer) MessageStats() []MsgStats {
+	list := make([]MsgStats, 0, len(s.router.codes))
+	for _, code := range s.router.codes {
+		stats := s.router.stats[code]
+		list = append(list, MsgStats{
+			Code:     stats.Code,
+			Name:     stats.Name,
+			Received: atomic.LoadUint64(&stats.Received),
+			Bytes:    atomic.LoadUint64(&stats.Bytes),
+			Invalid:  atomic.LoadUint64(&stats.Invalid),
+			Limited:  atomic.LoadUint64(&stats.Limited),
+			Failed:   atomic.LoadUint64(&stats.Failed),
+			Panics:   atomic.LoadUint64(&stats.Panics),
+		})
+	}
+	return list
+}
+
+// listen 订阅所有已注册的消息，统一分发
+func (s *syncer) listen() error {
+	msgCh := make(chan *models.P2PMessage, len(s.router.codes))
+	var scope event.SubscriptionScope
+	defer scope.Close()
+
+	for _, code := range s.router.codes {
+		sub := scope.Track(s.p2p.SubscribeMsg(uint(code), msgCh))
+		go func(name string, sub event.Subscription) {
+			select {
+			case err := <-sub.Err():
+				if err != nil {
+					s.log.Error("msg subscription err", "msg", name, "err", err)
+				}
+			case <-s.quitCh:
+			}
+		}(s.router.handlers[code].name, sub)
+	}
+
+	for {
+		select {
+		case msg := <-msgCh:
+			if err := s.handleMsg(msg); err != nil {
+				if errors.Is(err, errStopped) {
+					return nil
+				}
+				s.log.Debug("handle msg err", "peer", msg.Peer, "code", msg.Type, "err", err)
+			}
+			s.replayed(TraceInbound, uint64(msg.Type))
+		case <-s.quitCh:
+			return nil
+		}
+	}
+}
+
+// recoverPanic 恢复处理节点消息时的panic，归咎于发送方并断开该节点，不影响本节点运行。
+// 需直接通过defer调用，errp不为空时设置为对应的错误
+func (s *syncer) recoverPanic(peerId models.P2PID, code uint64, errp *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if stats, ok := s.router.stats[code]; ok {
+		atomic.AddUint64(&stats.Panics, 1)
+	}
+	err := fmt.Errorf("%w: code=%d: %v", errHandlerPanic, code, r)
+	s.log.Error("message handler panic", "peer", peerId, "code", code, "err", r, "stack", string(debug.Stack()))
+	s.rejectPeer(peerId, err)
+	if errp != nil {
+		*errp = err
+	}
+}
+
+// handleMsg 限流、解码并处理入站消息。超过限流及无效的消息计入节点的惩罚，处理过程中的panic归咎于发送方并断开该节点
+func (s *syncer) handleMsg(msg *models.P2PMessage) (err error) {
+	code := uint64(msg.Type)
+	defer s.recoverPanic(msg.Peer, code, &err)
+
+	h, ok := s.router.handlers[code]
+	if !ok {
+		return fmt.Errorf("%w: %d", errUnknownMsg, code)
+	}
+	stats := s.router.stats[code]
+	atomic.AddUint64(&stats.Received, 1)
+	atomic.AddUint64(&stats.Bytes, uint64(len(msg.Data)))
+
+	if !s.router.allow(msg.Peer, h.rate, s.clock.Now()) {
+		atomic.AddUint64(&stats.Limited, 1)
+		err := fmt.Errorf("%w: %s", errRateLimited, h.name)
+		s.penalize(msg.Peer, err)
+		return err
+	}
+	decoded, err := s.decodeMsg(h, msg)
+	if err != nil {
+		atomic.AddUint64(&stats.Invalid, 1)
+		s.penalize(msg.Peer, err)
+		return err
+	}
+	if err := h.handle(s, msg.Peer, decoded); err != nil {
+		if !errors.Is(err, errStopped) {
+			atomic.AddUint64(&stats.Failed, 1)
+		}
+		return err
+	}
+	return nil
+}
+
+// dispatchMsg 解码消息后交给对应的处理函数，不限流也不恢复panic
+func (s *syncer) dispatchMsg(msg *models.P2PMessage) error {
+	h, ok := s.router.handlers[uint64(msg.Type)]
+	if !ok {
+		return fmt.Errorf("%w: %d", errUnknownMsg, msg.Type)
+	}
+	decoded, err := s.decodeMsg(h, msg)
+	if err != nil {
+		return err
+	}
+	return h.handle(s, msg.Peer, decoded)
+}
+
+// decodeMsg 按声明校验大小、解压、解码并校验元素个数
+func (s *syncer) decodeMsg(h *msgHandler, msg *models.P2PMessage) (interface{}, error) {
+	data := msg.Data
+	limit := h.maxSize + 1 // 编码版本号
+	if h.compressed {
+		limit++ // 压缩算法前缀
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("%w: %s size=%d limit=%d", errMsgTooLarge, h.name, len(data), h.maxSize)
+	}
+	// 状态交换之前尚未协商编码，状态消息始终使用不带版本号的RLP
+	wireCodec := s.codecs.codecs[CodecRLP]
+	if !h.fixedCodec {
+		var err error
+		if wireCodec, data, err = s.unframe(msg.Peer, data); err != nil {
+			return nil, err
+		}
+	}
+	if h.compressed {
+		var err error
+		if data, err = s.decodePayload(msg.Peer, h.code, data); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > h.maxSize {
+		return nil, fmt.Errorf("%w: %s size=%d limit=%d", errMsgTooLarge, h.name, len(data), h.maxSize)
+	}
+	decoded := h.newMsg()
+	if err := wireCodec.Decode(data, decoded); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidMsg, h.name, err)
+	}
+	if h.items != nil {
+		n, err := h.items(decoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", errInvalidMsg, h.name, err)
+		}
+		if h.maxItems > 0 && n > h.maxItems {
+			return nil, fmt.Errorf("%w: %s items=%d limit=%d", errTooManyItems, h.name, n, h.maxItems)
+		}
+	}
+	return decoded, nil
+}
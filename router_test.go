@@ -0,0 +1,187 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// penalties 节点被惩罚的次数
+func penalties(p *peer) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.penalties
+}
+
+// msgStats code消息的统计
+func msgStats(s *syncer, code uint64) MsgStats {
+	for _, stats := range s.MessageStats() {
+		if stats.Code == code {
+			return stats
+		}
+	}
+	return MsgStats{}
+}
+
+func TestHandleMsgRejectsOverLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		code uint64
+		data func(s *syncer, id models.P2PID) []byte
+		err  error
+	}{
+		{
+			name: "too large",
+			code: NewBlockHashesMsg,
+			data: func(s *syncer, id models.P2PID) []byte { return bytes.Repeat([]byte{1}, 64*1024+2) },
+			err:  errMsgTooLarge,
+		},
+		{
+			name: "too many items",
+			code: GetBlockBodiesMsg,
+			data: func(s *syncer, id models.P2PID) []byte {
+				data, _ := s.encodeMsg(id, GetBlockBodiesMsg, make([]types.Hash, MaxBodyFetch+1))
+				return data
+			},
+			err: errTooManyItems,
+		},
+		{
+			name: "undecodable",
+			code: GetBlockBodiesMsg,
+			data: func(s *syncer, id models.P2PID) []byte { return []byte{byte(CodecRLP), 0xff} },
+			err:  errInvalidMsg,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSyncer(t, newTestChain())
+			defer s.Stop()
+
+			const id = models.P2PID("remote")
+			remote := newPeer(s.p2p, id)
+			s.peers.Register(remote, nil)
+
+			err := s.handleMsg(&models.P2PMessage{Type: uint(tt.code), Peer: id, Data: tt.data(s, id)})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("handle err = %v, want %v", err, tt.err)
+			}
+			if stats := msgStats(s, tt.code); stats.Invalid != 1 || stats.Received != 1 {
+				t.Fatalf("stats = %+v, want 1 invalid", stats)
+			}
+			if n := penalties(remote); n != 1 {
+				t.Fatalf("penalties = %d, want 1", n)
+			}
+			if queued(remote, BlockBodiesMsg) != 0 {
+				t.Fatalf("rejected request was served")
+			}
+		})
+	}
+}
+
+func TestHandleMsgRateLimit(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain())
+	defer s.Stop()
+	clock := s.clock.(*mclock.Simulated)
+
+	const id = models.P2PID("remote")
+	remote := newPeer(s.p2p, id)
+	s.peers.Register(remote, nil)
+	request, err := s.encodeMsg(id, GetBlockBodiesMsg, []types.Hash{})
+	if err != nil {
+		t.Fatalf("encode request: %v", err)
+	}
+	send := func() error {
+		return s.handleMsg(&models.P2PMessage{Type: GetBlockBodiesMsg, Peer: id, Data: request})
+	}
+
+	// 突发上限内的请求都被处理
+	limit := rateLimits[rateRequest]
+	for i := 0; i < int(limit.burst); i++ {
+		if err := send(); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if err := send(); !errors.Is(err, errRateLimited) {
+		t.Fatalf("request over burst err = %v, want %v", err, errRateLimited)
+	}
+	if stats := msgStats(s, GetBlockBodiesMsg); stats.Limited != 1 {
+		t.Fatalf("limited = %d, want 1", stats.Limited)
+	}
+	if n := penalties(remote); n != 1 {
+		t.Fatalf("penalties = %d, want 1", n)
+	}
+
+	// 令牌按速率恢复
+	clock.Run(time.Second)
+	for i := 0; i < int(limit.rate); i++ {
+		if err := send(); err != nil {
+			t.Fatalf("request %d after refill: %v", i, err)
+		}
+	}
+	if err := send(); !errors.Is(err, errRateLimited) {
+		t.Fatalf("request over rate err = %v, want %v", err, errRateLimited)
+	}
+}
+
+func TestListenRecoversHandlerPanic(t *testing.T) {
+	const (
+		panicMsg = 0x70
+		echoMsg  = 0x71
+	)
+	s, p2p := newTestSyncer(t, newTestChain())
+	handled := make(chan models.P2PID, 1)
+	if err := s.router.register(
+		&msgHandler{
+			code: panicMsg, name: "panic", maxSize: 64, fixedCodec: true,
+			newMsg: func() interface{} { return new([]byte) },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error { panic("handler bug") },
+		},
+		&msgHandler{
+			code: echoMsg, name: "echo", maxSize: 64, fixedCodec: true,
+			newMsg: func() interface{} { return new([]byte) },
+			handle: func(s *syncer, peerId models.P2PID, msg interface{}) error {
+				handled <- peerId
+				return nil
+			},
+		},
+	); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer s.Stop()
+	waitSubscribed(t, s, p2p)
+
+	data, err := codec.Coder().Encode([]byte{1})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	p2p.deliver(&models.P2PMessage{Type: panicMsg, Peer: "bad", Data: data})
+	p2p.deliver(&models.P2PMessage{Type: echoMsg, Peer: "good", Data: data})
+
+	// panic后消息循环继续处理其他消息
+	select {
+	case id := <-handled:
+		if id != "good" {
+			t.Fatalf("handled message from %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message loop stopped after handler panic")
+	}
+	if panics := atomic.LoadUint64(&s.router.stats[panicMsg].Panics); panics != 1 {
+		t.Fatalf("panics = %d, want 1", panics)
+	}
+	if !s.bans.banned("bad") {
+		t.Fatalf("panicking peer not banned")
+	}
+}
@@ -0,0 +1,181 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-protocol/models"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// PeerInfo 节点选择策略使用的节点信息
+type PeerInfo struct {
+	Id          models.P2PID  `json:"id"`
+	Head        ChainHead     `json:"head"`        // 对方的链头
+	HistoryFrom uint64        `json:"historyFrom"` // 可提供完整区块的最低高度
+	RTT         time.Duration `json:"rtt"`         // 往返时间估计，未测量时为0
+	Score       float64       `json:"score"`       // 按吞吐量及惩罚次数计算的得分，越大越好
+}
+
+// PeerSelector 节点选择策略，用于同步下载及按需获取
+type PeerSelector interface {
+	// Select 从候选节点中选择一个，返回其下标，不选择时返回-1。
+	// candidates非空、按Id排序，且都满足请求的区块范围；target为请求需要的最低高度，未知时为0
+	Select(candidates []PeerInfo, target uint64) int
+}
+
+// PeerSelectorFunc 函数形式的PeerSelector
+type PeerSelectorFunc func(candidates []PeerInfo, target uint64) int
+
+func (f PeerSelectorFunc) Select(candidates []PeerInfo, target uint64) int {
+	return f(candidates, target)
+}
+
+// SelectByHead 选择链头最优的节点，链头相同时选择往返时间较短的。compare为空时使用CompareByWeight
+func SelectByHead(compare HeadComparator) PeerSelector {
+	if compare == nil {
+		compare = CompareByWeight
+	}
+	return PeerSelectorFunc(func(candidates []PeerInfo, target uint64) int {
+		best := 0
+		for i := 1; i < len(candidates); i++ {
+			c := compare(candidates[i].Head, candidates[best].Head)
+			if c > 0 || (c == 0 && latency(candidates[i]) < latency(candidates[best])) {
+				best = i
+			}
+		}
+		return best
+	})
+}
+
+// SelectByLatency 在高度不低于target的节点中选择往返时间最短的，未测量的节点排在最后
+func SelectByLatency() PeerSelector {
+	return PeerSelectorFunc(func(candidates []PeerInfo, target uint64) int {
+		best := -1
+		for i, info := range candidates {
+			if info.Head.Height < target {
+				continue
+			}
+			if best < 0 || latency(info) < latency(candidates[best]) {
+				best = i
+			}
+		}
+		return best
+	})
+}
+
+// SelectByScore 按得分加权随机选择，分散各节点的负载
+func SelectByScore() PeerSelector {
+	return PeerSelectorFunc(func(candidates []PeerInfo, target uint64) int {
+		var total float64
+		for _, info := range candidates {
+			total += info.Score
+		}
+		if total <= 0 {
+			return rand.Intn(len(candidates))
+		}
+		r := rand.Float64() * total
+		for i, info := range candidates {
+			if r -= info.Score; r < 0 {
+				return i
+			}
+		}
+		return len(candidates) - 1
+	})
+}
+
+// SelectPreferred 按顺序选择preferred中的节点，都不可用时使用fallback，fallback为空时使用SelectByHead
+func SelectPreferred(preferred []models.P2PID, fallback PeerSelector) PeerSelector {
+	if fallback == nil {
+		fallback = SelectByHead(nil)
+	}
+	rank := make(map[models.P2PID]int, len(preferred))
+	for i, id := range preferred {
+		if _, ok := rank[id]; !ok {
+			rank[id] = i
+		}
+	}
+	return PeerSelectorFunc(func(candidates []PeerInfo, target uint64) int {
+		best := -1
+		for i, info := range candidates {
+			if r, ok := rank[info.Id]; ok && (best < 0 || r < rank[candidates[best].Id]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+		return fallback.Select(candidates, target)
+	})
+}
+
+// latency 用于比较的往返时间，未测量时按最大超时时间计算
+func latency(info PeerInfo) time.Duration {
+	if info.RTT == 0 {
+		return p2pFetchTimeout
+	}
+	return info.RTT
+}
+
+// info 节点选择策略使用的信息
+func (p *peer) info() PeerInfo {
+	p.mu.RLock()
+	penalties := p.penalties
+	p.mu.RUnlock()
+
+	rates, rtt := p.throughput.snapshot()
+	return PeerInfo{
+		Id:          p.P2PID,
+		Head:        p.ChainHead(),
+		HistoryFrom: p.HistoryFrom(),
+		RTT:         rtt,
+		Score:       (1 + rates[headerFetch] + rates[bodyFetch]) / float64(1+penalties),
+	}
+}
+
+// selectPeer 按策略从满足accept的同步来源中选择，跳过exclude中的节点
+func (ps *peerSet) selectPeer(target uint64, exclude map[models.P2PID]bool, accept func(p *peer, head ChainHead) bool) *peer {
+	ps.lock.RLock()
+	var (
+		peers      []*peer
+		candidates []PeerInfo
+	)
+	for id, p := range ps.peers {
+		if exclude[id] || !p.SyncSource() {
+			continue
+		}
+		info := p.info()
+		if !accept(p, info.Head) {
+			continue
+		}
+		peers = append(peers, p)
+		candidates = append(candidates, info)
+	}
+	selector := ps.selector
+	ps.lock.RUnlock()
+
+	if len(candidates) == 0 {
+		return nil
+	}
+	// 按Id排序，相同条件下的选择结果与map的遍历顺序无关
+	sort.Sort(&peersById{peers: peers, infos: candidates})
+	index := selector.Select(candidates, target)
+	if index < 0 || index >= len(peers) {
+		return nil
+	}
+	return peers[index]
+}
+
+type peersById struct {
+	peers []*peer
+	infos []PeerInfo
+}
+
+func (s *peersById) Len() int           { return len(s.peers) }
+func (s *peersById) Less(i, j int) bool { return s.infos[i].Id < s.infos[j].Id }
+func (s *peersById) Swap(i, j int) {
+	s.peers[i], s.peers[j] = s.peers[j], s.peers[i]
+	s.infos[i], s.infos[j] = s.infos[j], s.infos[i]
+}
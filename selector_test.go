@@ -0,0 +1,79 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"github.com/chain5j/chain5j-protocol/models"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestPeerSelectors(t *testing.T) {
+	candidates := []PeerInfo{
+		{Id: "a", Head: ChainHead{Height: 50, Weight: big.NewInt(300)}, RTT: 10 * time.Millisecond},
+		{Id: "b", Head: ChainHead{Height: 200, Weight: big.NewInt(200)}, RTT: 100 * time.Millisecond},
+		{Id: "c", Head: ChainHead{Height: 200, Weight: big.NewInt(200)}, RTT: 30 * time.Millisecond, Score: 5},
+		{Id: "d", Head: ChainHead{Height: 300, Weight: big.NewInt(100)}},
+	}
+	tests := []struct {
+		name     string
+		selector PeerSelector
+		target   uint64
+		want     models.P2PID
+	}{
+		{name: "head by weight", selector: SelectByHead(nil), want: "a"},
+		{name: "head by height", selector: SelectByHead(CompareByHeight), want: "d"},
+		{name: "head tie by latency", selector: SelectByHead(func(a, b ChainHead) int { return 0 }), want: "a"},
+		{name: "latency without target", selector: SelectByLatency(), want: "a"},
+		{name: "latency above target", selector: SelectByLatency(), target: 100, want: "c"},
+		{name: "latency unmeasured last", selector: SelectByLatency(), target: 250, want: "d"},
+		{name: "latency no peer at target", selector: SelectByLatency(), target: 400},
+		{name: "score", selector: SelectByScore(), want: "c"},
+		{name: "preferred order", selector: SelectPreferred([]models.P2PID{"x", "d", "b"}, nil), want: "d"},
+		{name: "preferred fallback", selector: SelectPreferred([]models.P2PID{"x"}, SelectByLatency()), target: 100, want: "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.P2PID
+			if index := tt.selector.Select(candidates, tt.target); index >= 0 {
+				got = candidates[index].Id
+			}
+			if got != tt.want {
+				t.Fatalf("selected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipelineSelectsPeerForChunkTarget(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain(), WithPeerSelector(SelectByLatency()))
+	defer s.Stop()
+
+	// 不启动发送协程，请求留在发送队列中。a-low延迟更低，但链头低于请求的最后一个高度
+	var peers []*peer
+	for _, tt := range []struct {
+		id     models.P2PID
+		height uint64
+		rtt    time.Duration
+	}{
+		{id: "a-low", height: 5, rtt: 10 * time.Millisecond},
+		{id: "b-high", height: 200, rtt: 100 * time.Millisecond},
+	} {
+		p := newPeer(s.p2p, tt.id)
+		p.SetChainHead(ChainHead{Height: tt.height})
+		p.throughput.rtt = tt.rtt
+		s.peers.Register(p, nil)
+		peers = append(peers, p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	(&p2pSource{s: s}).pipelineHeaders(ctx, 1, 10)
+
+	if got := requestedPeers(peers, GetBlockHeadersMsg); len(got) != 1 || got[0] != "b-high" {
+		t.Fatalf("requested from %v, want only b-high", got)
+	}
+}
@@ -0,0 +1,190 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"github.com/chain5j/chain5j-protocol/models"
+	"sync"
+)
+
+// sendPriority 发送消息的优先级，数值越小越先发送
+type sendPriority int
+
+const (
+	prioControl  sendPriority = iota // 状态交换等控制消息
+	prioAnnounce                     // 区块通知
+	prioRequest                      // 本节点发出的请求
+	prioResponse                     // header及body的批量响应
+	prioTx                           // 交易广播
+	numSendPriorities
+)
+
+// dropPolicy 队列已满时的处理方式
+type dropPolicy int
+
+const (
+	dropNewest dropPolicy = iota // 丢弃新消息并返回错误
+	dropOldest                   // 丢弃最早的消息，用于时效性强的广播
+)
+
+// sendQueueConfig 各优先级的队列长度及已满时的处理方式
+var sendQueueConfig = [numSendPriorities]struct {
+	size   int
+	policy dropPolicy
+}{
+	prioControl:  {size: 16, policy: dropNewest},
+	prioAnnounce: {size: 64, policy: dropOldest},
+	prioRequest:  {size: 64, policy: dropNewest},
+	prioResponse: {size: 32, policy: dropNewest},
+	prioTx:       {size: 128, policy: dropOldest},
+}
+
+// sendPriorities 消息类型对应的优先级，未列出的为prioControl
+var sendPriorities = map[uint64]sendPriority{
+	NewBlockHashesMsg:  prioAnnounce,
+	GetBlockHeadersMsg: prioRequest,
+	GetBlockBodiesMsg:  prioRequest,
+	BlockHeadersMsg:    prioResponse,
+	BlockBodiesMsg:     prioResponse,
+	TxMsg:              prioTx,
+}
+
+var errSendQueueFull = errors.New("peer send queue full")
+
+// SendQueueStats 节点发送队列的统计
+type SendQueueStats struct {
+	Depth    int    `json:"depth"`    // 当前排队的消息数
+	MaxDepth int    `json:"maxDepth"` // 排队消息数的最大值
+	Sent     uint64 `json:"sent"`     // 已发送的消息数
+	Dropped  uint64 `json:"dropped"`  // 队列已满被丢弃的消息数
+	Failed   uint64 `json:"failed"`   // 发送失败的消息数
+}
+
+// sendQueue 节点的有界发送队列，同一优先级内按顺序发送
+type sendQueue struct {
+	queues [numSendPriorities][]*models.P2PMessage
+	wake   chan struct{}
+	stats  SendQueueStats
+	lock   sync.Mutex
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{wake: make(chan struct{}, 1)}
+}
+
+// push 将消息放入对应优先级的队列
+func (q *sendQueue) push(msg *models.P2PMessage) error {
+	prio, ok := sendPriorities[uint64(msg.Type)]
+	if !ok {
+		prio = prioControl
+	}
+	config := sendQueueConfig[prio]
+
+	q.lock.Lock()
+	queue := q.queues[prio]
+	if len(queue) >= config.size {
+		q.stats.Dropped++
+		if config.policy == dropNewest {
+			q.lock.Unlock()
+			return errSendQueueFull
+		}
+		queue[0] = nil
+		queue = queue[1:]
+		q.stats.Depth--
+	}
+	q.queues[prio] = append(queue, msg)
+	q.stats.Depth++
+	if q.stats.Depth > q.stats.MaxDepth {
+		q.stats.MaxDepth = q.stats.Depth
+	}
+	q.lock.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pop 取出优先级最高的消息，队列为空时返回nil
+func (q *sendQueue) pop() *models.P2PMessage {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for prio := range q.queues {
+		if queue := q.queues[prio]; len(queue) > 0 {
+			msg := queue[0]
+			queue[0] = nil
+			q.queues[prio] = queue[1:]
+			q.stats.Depth--
+			return msg
+		}
+	}
+	return nil
+}
+
+func (q *sendQueue) sent(err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err != nil {
+		q.stats.Failed++
+	} else {
+		q.stats.Sent++
+	}
+}
+
+func (q *sendQueue) snapshot() SendQueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.stats
+}
+
+// sendLoop 按优先级依次发送队列中的消息，节点断开后退出，未发送的消息被丢弃
+func (p *peer) sendLoop() {
+	for {
+		msg := p.queue.pop()
+		if msg == nil {
+			select {
+			case <-p.queue.wake:
+				continue
+			case <-p.quitCh:
+				return
+			}
+		}
+		p.queue.sent(p.p2p.Send(p.P2PID, msg))
+
+		select {
+		case <-p.quitCh:
+			return
+		default:
+		}
+	}
+}
+
+// send 通过节点的发送队列发送消息，未注册的节点(如状态交换期间)直接发送
+func (s *syncer) send(peerId models.P2PID, msg *models.P2PMessage) error {
+	// 回放时同步发送，发送的消息按事件的处理顺序记录
+	if s.replaySteps != nil {
+		return s.p2p.Send(peerId, msg)
+	}
+	if peer := s.peers.Peer(peerId); peer != nil {
+		return peer.queue.push(msg)
+	}
+	go func() {
+		if err := s.p2p.Send(peerId, msg); err != nil {
+			s.log.Debug("send msg err", "peer", peerId, "code", msg.Type, "err", err)
+		}
+	}()
+	return nil
+}
+
+// SendQueueStats 各节点发送队列的统计
+func (s *syncer) SendQueueStats() map[models.P2PID]SendQueueStats {
+	stats := make(map[models.P2PID]SendQueueStats)
+	for _, peer := range s.peers.Peers() {
+		stats[peer.P2PID] = peer.queue.snapshot()
+	}
+	return stats
+}
@@ -0,0 +1,94 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"github.com/chain5j/chain5j-protocol/models"
+	"testing"
+)
+
+func TestSendQueueDropPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		code uint64
+		prio sendPriority
+	}{
+		{name: "control", code: StatusMsg, prio: prioControl},
+		{name: "announce", code: NewBlockHashesMsg, prio: prioAnnounce},
+		{name: "request", code: GetBlockHeadersMsg, prio: prioRequest},
+		{name: "response", code: BlockBodiesMsg, prio: prioResponse},
+		{name: "tx", code: TxMsg, prio: prioTx},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sendQueueConfig[tt.prio]
+			q := newSendQueue()
+			for i := 0; i < config.size; i++ {
+				if err := q.push(&models.P2PMessage{Type: uint(tt.code), Data: []byte{byte(i)}}); err != nil {
+					t.Fatalf("push %d: %v", i, err)
+				}
+			}
+			// 队列已满，按策略丢弃新消息或最早的消息
+			err := q.push(&models.P2PMessage{Type: uint(tt.code), Data: []byte{byte(config.size)}})
+			first := byte(0)
+			switch config.policy {
+			case dropNewest:
+				if !errors.Is(err, errSendQueueFull) {
+					t.Fatalf("push to full queue err = %v, want %v", err, errSendQueueFull)
+				}
+			case dropOldest:
+				if err != nil {
+					t.Fatalf("push to full queue err = %v", err)
+				}
+				first = 1
+			}
+			stats := q.snapshot()
+			if stats.Depth != config.size || stats.MaxDepth != config.size || stats.Dropped != 1 {
+				t.Fatalf("stats = %+v, want depth %d and 1 dropped", stats, config.size)
+			}
+			for i := 0; i < config.size; i++ {
+				msg := q.pop()
+				if msg == nil || msg.Data[0] != first+byte(i) {
+					t.Fatalf("pop %d = %v, want data %d", i, msg, first+byte(i))
+				}
+			}
+			if msg := q.pop(); msg != nil {
+				t.Fatalf("queue not empty after %d pops", config.size)
+			}
+		})
+	}
+}
+
+func TestSendQueuePriorityOrder(t *testing.T) {
+	q := newSendQueue()
+	for _, code := range []uint64{TxMsg, BlockHeadersMsg, GetBlockBodiesMsg, NewBlockHashesMsg, StatusMsg} {
+		if err := q.push(&models.P2PMessage{Type: uint(code)}); err != nil {
+			t.Fatalf("push %d: %v", code, err)
+		}
+	}
+	for _, want := range []uint64{StatusMsg, NewBlockHashesMsg, GetBlockBodiesMsg, BlockHeadersMsg, TxMsg} {
+		if msg := q.pop(); msg == nil || uint64(msg.Type) != want {
+			t.Fatalf("pop = %v, want code %d", msg, want)
+		}
+	}
+}
+
+func TestStopClosesPeers(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain())
+	peer := registerTestPeer(s, "remote", ChainHead{})
+	s.Stop()
+
+	select {
+	case <-peer.quitCh:
+	default:
+		t.Fatalf("peer send loop not stopped")
+	}
+	if len(s.peers.Peers()) != 0 {
+		t.Fatalf("peers still registered after stop")
+	}
+	if err := s.peers.Register(newPeer(s.p2p, "late"), nil); !errors.Is(err, errClosed) {
+		t.Fatalf("register after stop err = %v, want %v", err, errClosed)
+	}
+}
@@ -0,0 +1,200 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"sync/atomic"
+)
+
+// ServeCacheConfig 服务端缓存配置，数量为0时关闭对应的缓存
+type ServeCacheConfig struct {
+	Headers  int // 缓存header的个数
+	Bodies   int // 缓存body的个数
+	Payloads int // 缓存已编码响应的个数
+}
+
+// DefaultServeCacheConfig 默认的服务端缓存配置
+var DefaultServeCacheConfig = ServeCacheConfig{
+	Headers:  4096,
+	Bodies:   512,
+	Payloads: 256,
+}
+
+// ServeCacheStats 服务端缓存命中统计
+type ServeCacheStats struct {
+	HeaderHits    uint64 `json:"header_hits"`
+	HeaderMisses  uint64 `json:"header_misses"`
+	BodyHits      uint64 `json:"body_hits"`
+	BodyMisses    uint64 `json:"body_misses"`
+	PayloadHits   uint64 `json:"payload_hits"`
+	PayloadMisses uint64 `json:"payload_misses"`
+	Purges        uint64 `json:"purges"` // 因reorg清空的次数
+}
+
+// HeaderHitRate header命中率
+func (s ServeCacheStats) HeaderHitRate() float64 { return hitRate(s.HeaderHits, s.HeaderMisses) }
+
+// BodyHitRate body命中率
+func (s ServeCacheStats) BodyHitRate() float64 { return hitRate(s.BodyHits, s.BodyMisses) }
+
+// PayloadHitRate 已编码响应的命中率
+func (s ServeCacheStats) PayloadHitRate() float64 { return hitRate(s.PayloadHits, s.PayloadMisses) }
+
+func hitRate(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// serveCache 缓存最近提供给远程节点的header、body及编码后的响应，
+// 避免大量节点同时同步相同区间时反复读取数据库。
+// header、body以hash为key，内容不可变；number==>hash及响应与规范链相关，reorg时需清空
+type serveCache struct {
+	blockRW interface {
+		GetHeader(hash types.Hash, number uint64) *models.Header
+		GetHeaderByHash(hash types.Hash) *models.Header
+		GetHeaderByNumber(number uint64) *models.Header
+		GetBody(hash types.Hash) *models.Body
+	}
+
+	headers  *lruCache // hash==>*models.Header
+	numbers  *lruCache // number==>hash
+	bodies   *lruCache // hash==>*models.Body
+	payloads *lruCache // 请求==>[]byte
+
+	headerHits, headerMisses   uint64
+	bodyHits, bodyMisses       uint64
+	payloadHits, payloadMisses uint64
+	purges                     uint64
+}
+
+func newServeCache(config ServeCacheConfig) *serveCache {
+	c := new(serveCache)
+	if config.Headers > 0 {
+		c.headers = newLRUCache(config.Headers)
+		c.numbers = newLRUCache(config.Headers)
+	}
+	if config.Bodies > 0 {
+		c.bodies = newLRUCache(config.Bodies)
+	}
+	if config.Payloads > 0 {
+		c.payloads = newLRUCache(config.Payloads)
+	}
+	return c
+}
+
+func (c *serveCache) GetHeaderByHash(hash types.Hash) *models.Header {
+	if header, ok := c.headers.Get(hash); ok {
+		atomic.AddUint64(&c.headerHits, 1)
+		return header.(*models.Header)
+	}
+	atomic.AddUint64(&c.headerMisses, 1)
+	header := c.blockRW.GetHeaderByHash(hash)
+	if header != nil {
+		c.headers.Add(hash, header)
+	}
+	return header
+}
+
+func (c *serveCache) GetHeader(hash types.Hash, number uint64) *models.Header {
+	if header, ok := c.headers.Get(hash); ok {
+		atomic.AddUint64(&c.headerHits, 1)
+		return header.(*models.Header)
+	}
+	atomic.AddUint64(&c.headerMisses, 1)
+	header := c.blockRW.GetHeader(hash, number)
+	if header != nil {
+		c.headers.Add(hash, header)
+	}
+	return header
+}
+
+func (c *serveCache) GetHeaderByNumber(number uint64) *models.Header {
+	if hash, ok := c.numbers.Get(number); ok {
+		if header, ok := c.headers.Get(hash); ok {
+			atomic.AddUint64(&c.headerHits, 1)
+			return header.(*models.Header)
+		}
+	}
+	atomic.AddUint64(&c.headerMisses, 1)
+	header := c.blockRW.GetHeaderByNumber(number)
+	if header != nil {
+		hash := header.Hash()
+		c.headers.Add(hash, header)
+		c.numbers.Add(number, hash)
+	}
+	return header
+}
+
+func (c *serveCache) GetBody(hash types.Hash) *models.Body {
+	if body, ok := c.bodies.Get(hash); ok {
+		atomic.AddUint64(&c.bodyHits, 1)
+		return body.(*models.Body)
+	}
+	atomic.AddUint64(&c.bodyMisses, 1)
+	body := c.blockRW.GetBody(hash)
+	if body != nil {
+		c.bodies.Add(hash, body)
+	}
+	return body
+}
+
+// payload 获取已编码的响应
+func (c *serveCache) payload(key string) ([]byte, bool) {
+	if c.payloads == nil {
+		return nil, false
+	}
+	if data, ok := c.payloads.Get(key); ok {
+		atomic.AddUint64(&c.payloadHits, 1)
+		return data.([]byte), true
+	}
+	atomic.AddUint64(&c.payloadMisses, 1)
+	return nil, false
+}
+
+func (c *serveCache) setPayload(key string, data []byte) {
+	c.payloads.Add(key, data)
+}
+
+// purge 清空与规范链相关的缓存
+func (c *serveCache) purge() {
+	c.numbers.Purge()
+	c.payloads.Purge()
+	atomic.AddUint64(&c.purges, 1)
+}
+
+func (c *serveCache) stats() ServeCacheStats {
+	return ServeCacheStats{
+		HeaderHits:    atomic.LoadUint64(&c.headerHits),
+		HeaderMisses:  atomic.LoadUint64(&c.headerMisses),
+		BodyHits:      atomic.LoadUint64(&c.bodyHits),
+		BodyMisses:    atomic.LoadUint64(&c.bodyMisses),
+		PayloadHits:   atomic.LoadUint64(&c.payloadHits),
+		PayloadMisses: atomic.LoadUint64(&c.payloadMisses),
+		Purges:        atomic.LoadUint64(&c.purges),
+	}
+}
+
+func headersPayloadKey(query ext.GetBlockHeadersData, version CodecVersion) string {
+	return fmt.Sprintf("h%d:%x:%d:%d:%t", version, query.Origin.Hash, query.Origin.Number, query.Amount, query.Reverse)
+}
+
+func bodiesPayloadKey(hashes []types.Hash, version CodecVersion) string {
+	key := make([]byte, 0, 3+len(hashes)*types.HashLength)
+	key = append(key, 'b', byte(version), ':')
+	for _, hash := range hashes {
+		key = append(key, hash[:]...)
+	}
+	return string(key)
+}
+
+// ServeCacheStats 服务端缓存的命中统计
+func (s *syncer) ServeCacheStats() ServeCacheStats {
+	return s.serveCache.stats()
+}
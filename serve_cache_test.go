@@ -0,0 +1,69 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-protocol/models"
+	"testing"
+)
+
+func TestServeCachePurgeOnReorg(t *testing.T) {
+	tests := []struct {
+		name    string
+		newHead func(chain *testChain, main []*models.Header) *models.Block
+		purged  bool
+	}{
+		{
+			name: "extended",
+			newHead: func(chain *testChain, main []*models.Header) *models.Block {
+				blocks := chain.extend(testHeaders(main[len(main)-1], 2, 0))
+				return blocks[len(blocks)-1]
+			},
+		},
+		{
+			name: "reorg",
+			newHead: func(chain *testChain, main []*models.Header) *models.Block {
+				blocks := chain.extend(testHeaders(chain.GetHeaderByNumber(0), 4, 1))
+				return blocks[len(blocks)-1]
+			},
+			purged: true,
+		},
+		{
+			name: "unknown ancestor",
+			newHead: func(chain *testChain, main []*models.Header) *models.Block {
+				orphan := testHeader(testHeader(testHeader(nil, 2), 2), 2)
+				return testBlock(orphan)
+			},
+			purged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain()
+			main := testHeaders(chain.GetHeaderByNumber(0), 3, 0)
+			oldHead := chain.extend(main)[len(main)-1]
+			s, _ := newTestSyncer(t, chain)
+
+			// 按高度缓存规范链的header及编码后的响应
+			if header := s.serveCache.GetHeaderByNumber(1); header.Hash() != main[0].Hash() {
+				t.Fatalf("cached header mismatch")
+			}
+			s.serveCache.setPayload("key", []byte{1})
+
+			s.headReplaced(oldHead, tt.newHead(chain, main))
+
+			stats := s.ServeCacheStats()
+			if purged := stats.Purges == 1; purged != tt.purged {
+				t.Fatalf("purges = %d, want purged %t", stats.Purges, tt.purged)
+			}
+			if _, ok := s.serveCache.payload("key"); ok == tt.purged {
+				t.Fatalf("payload cached = %t, want %t", ok, !tt.purged)
+			}
+			want := chain.GetHeaderByNumber(1).Hash()
+			if got := s.serveCache.GetHeaderByNumber(1).Hash(); tt.purged && got != want {
+				t.Fatalf("stale header by number after purge: got %s want %s", got.Hex(), want.Hex())
+			}
+		})
+	}
+}
@@ -4,7 +4,8 @@
 package syncer
 
 import (
-	"github.com/chain5j/chain5j-pkg/codec"
+	"errors"
+	"fmt"
 	"github.com/chain5j/chain5j-pkg/types"
 	"github.com/chain5j/chain5j-protocol/models"
 	"github.com/chain5j/chain5j-protocol/models/ext"
@@ -29,6 +30,7 @@ const (
 	NodeDataMsg        = 0x0e
 	GetReceiptsMsg     = 0x0f
 	ReceiptsMsg        = 0x10
+	NotAvailableMsg    = 0x11 // 请求的区块超出可提供的范围
 )
 
 var (
@@ -44,9 +46,39 @@ var (
 
 // 查询BlockHeader进行发送
 func (s *syncer) SendBlockHeaders(peerId models.P2PID, query ext.GetBlockHeadersData) {
+	format := s.wireFormat(peerId)
+	toBytes, err := s.serveHeaders(query, format.version)
+	if errors.Is(err, errOutOfRange) {
+		s.sendNotAvailable(peerId, &notAvailableData{Code: GetBlockHeadersMsg, Origin: query.Origin})
+		return
+	}
+	if err != nil {
+		s.log.Error("headers codec.Encode err", "err", err)
+		return
+	}
+	if err := s.send(peerId, &models.P2PMessage{
+		Type: BlockHeadersMsg,
+		Peer: "",
+		Data: s.frame(peerId, BlockHeadersMsg, format, toBytes),
+	}); err != nil {
+		s.log.Debug("send headers err", "peer", peerId, "err", err)
+	}
+}
+
+// serveHeaders 查询header并按编码版本编码，p2p及mirror共用
+func (s *syncer) serveHeaders(query ext.GetBlockHeadersData, version CodecVersion) ([]byte, error) {
+	historyFrom := s.HistoryFrom()
+	if query.Origin.Hash == (types.Hash{}) && query.Origin.Number < historyFrom {
+		return nil, fmt.Errorf("%w: number=%d from=%d", errOutOfRange, query.Origin.Number, historyFrom)
+	}
+	cacheKey := headersPayloadKey(query, version)
+	if payload, ok := s.serveCache.payload(cacheKey); ok {
+		return payload, nil
+	}
+
 	hashMode := query.Origin.Hash != (types.Hash{})
 	first := true
-	maxNonCanonical := uint64(100)
+	amount := query.Amount
 
 	var (
 		bytes   types.StorageSize
@@ -60,19 +92,26 @@ func (s *syncer) SendBlockHeaders(peerId models.P2PID, query ext.GetBlockHeaders
 		if hashMode {
 			if first {
 				first = false
-				origin = s.blockRW.GetHeaderByHash(query.Origin.Hash)
+				origin = s.serveCache.GetHeaderByHash(query.Origin.Hash)
 				if origin != nil {
 					query.Origin.Number = origin.Height
 				}
 			} else {
-				origin = s.blockRW.GetHeader(query.Origin.Hash, query.Origin.Number)
+				origin = s.serveCache.GetHeader(query.Origin.Hash, query.Origin.Number)
 			}
 		} else {
-			origin = s.blockRW.GetHeaderByNumber(query.Origin.Number)
+			origin = s.serveCache.GetHeaderByNumber(query.Origin.Number)
 		}
 		if origin == nil {
 			break
 		}
+		// 已裁剪的区块不再提供
+		if origin.Height < historyFrom {
+			if len(headers) == 0 {
+				return nil, fmt.Errorf("%w: number=%d from=%d", errOutOfRange, origin.Height, historyFrom)
+			}
+			break
+		}
 		headers = append(headers, origin)
 		bytes += estHeaderRlpSize
 
@@ -81,11 +120,11 @@ func (s *syncer) SendBlockHeaders(peerId models.P2PID, query ext.GetBlockHeaders
 		case hashMode && query.Reverse:
 			// Hash based traversal towards the genesis block
 			// ancestor := query.Skip + 1
-			ancestor := uint64(1)
-			if ancestor == 0 {
+			// 步长为1时，祖先即为父区块，直接使用header中的ParentHash，避免GetAncestor查库
+			if origin.Height == 0 {
 				unknown = true
 			} else {
-				query.Origin.Hash, query.Origin.Number = s.blockRW.GetAncestor(query.Origin.Hash, query.Origin.Number, ancestor, &maxNonCanonical)
+				query.Origin.Hash, query.Origin.Number = origin.ParentHash, origin.Height-1
 				unknown = (query.Origin.Hash == types.Hash{})
 			}
 		case hashMode && !query.Reverse:
@@ -102,11 +141,10 @@ func (s *syncer) SendBlockHeaders(peerId models.P2PID, query ext.GetBlockHeaders
 				s.log.Warn("GetBlockHeaders skip overflow attack", "current", current, "next", next, "attacker", infos)
 				unknown = true
 			} else {
-				if header := s.blockRW.GetHeaderByNumber(next); header != nil {
+				if header := s.serveCache.GetHeaderByNumber(next); header != nil {
 					nextHash := header.Hash()
 					// expOldHash, _ := s.blockRW.GetAncestor(nextHash, next, query.Skip+1, &maxNonCanonical)
-					expOldHash, _ := s.blockRW.GetAncestor(nextHash, next, 1, &maxNonCanonical)
-					if expOldHash == query.Origin.Hash {
+					if header.ParentHash == query.Origin.Hash {
 						query.Origin.Hash, query.Origin.Number = nextHash, next
 					} else {
 						unknown = true
@@ -134,17 +172,15 @@ func (s *syncer) SendBlockHeaders(peerId models.P2PID, query ext.GetBlockHeaders
 			query.Origin.Number += 1
 		}
 	}
-	toBytes, err := codec.Coder().Encode(headers)
+	toBytes, err := s.marshal(wireFormat{version: version}, headers)
 	if err != nil {
-		s.log.Error("headers codec.Encode err", "err", err)
-		return
+		return nil, err
 	}
-
-	go s.p2p.Send(peerId, &models.P2PMessage{
-		Type: BlockHeadersMsg,
-		Peer: "",
-		Data: toBytes,
-	})
+	// 只缓存完整的响应，不完整的响应会随链的增长而变化
+	if uint64(len(headers)) == amount || len(headers) == MaxHeaderFetch || bytes >= softResponseLimit {
+		s.serveCache.setPayload(cacheKey, toBytes)
+	}
+	return toBytes, nil
 }
 
 // ====================body==============
@@ -153,92 +189,70 @@ func (s *syncer) SendBlockBodies(peerId models.P2PID, hashes []types.Hash) {
 		return
 	}
 
-	var body *models.Body
-
-	var bodies []*models.Body
-	for _, blockHash := range hashes {
-		body = s.blockRW.GetBody(blockHash)
-		bodies = append(bodies, body)
+	format := s.wireFormat(peerId)
+	toBytes, served, err := s.serveBodies(hashes, format.version)
+	if errors.Is(err, errOutOfRange) {
+		s.sendNotAvailable(peerId, &notAvailableData{Code: GetBlockBodiesMsg, Hash: hashes[0]})
+		return
 	}
-
-	toBytes, err := codec.Coder().Encode(bodies)
 	if err != nil {
 		s.log.Error("bodies codec.Encode err", "err", err)
 		return
 	}
-	go s.p2p.Send(peerId, &models.P2PMessage{
+	// 对方获取body后即拥有完整区块。只发送header时对方随后还会请求body，在此时记录即可。
+	// 本地没有的body未发送，对方仍未拥有该区块
+	if peer := s.peers.Peer(peerId); peer != nil {
+		peer.markBlocks(served...)
+	}
+	if err := s.send(peerId, &models.P2PMessage{
 		Type: BlockBodiesMsg,
 		Peer: "",
-		Data: toBytes,
-	})
+		Data: s.frame(peerId, BlockBodiesMsg, format, toBytes),
+	}); err != nil {
+		s.log.Debug("send bodies err", "peer", peerId, "err", err)
+	}
 }
 
-func (s *syncer) listen() error {
-	// =========接收请求=========
-	// 监听获取header
-	getBlockHeadersMsgCh := make(chan *models.P2PMessage, 1)
-	getBlockHeadersMsgSub := s.p2p.SubscribeMsg(GetBlockHeadersMsg, getBlockHeadersMsgCh)
-
-	getBlockBodiesMsgCh := make(chan *models.P2PMessage, 1)
-	getBlockBodiesMsgSub := s.p2p.SubscribeMsg(GetBlockBodiesMsg, getBlockBodiesMsgCh)
-
-	// =========接收响应=========
-	respondBlockHeadersMsgCh := make(chan *models.P2PMessage, 1)
-	respondBlockHeadersMsgSub := s.p2p.SubscribeMsg(BlockHeadersMsg, respondBlockHeadersMsgCh)
-
-	respondBlockBodiesMsgCh := make(chan *models.P2PMessage, 1)
-	respondBlockBodiesMsgSub := s.p2p.SubscribeMsg(BlockBodiesMsg, respondBlockBodiesMsgCh)
-
-	for {
-		select {
-		// 接收到请求
-		case blockHeadersMsg := <-getBlockHeadersMsgCh:
-			data := blockHeadersMsg.Data
-			var query ext.GetBlockHeadersData
-			if err := codec.Coder().Decode(data, &query); err != nil {
-				s.log.Error("getBlockHeadersData decode err", "msg", blockHeadersMsg, "err", err)
-				break
-			}
-			s.SendBlockHeaders(blockHeadersMsg.Peer, query)
-		case err := <-getBlockHeadersMsgSub.Err():
-			s.log.Error("getBlockHeadersMsgSub err", "err", err)
-			break
-
-		case ch := <-getBlockBodiesMsgCh:
-			data := ch.Data
-			var hashes []types.Hash
-			if err := codec.Coder().Decode(data, &hashes); err != nil {
-				s.log.Error("getBlockBodiesMsgCh decode err", "msg", ch, "err", err)
-				break
-			}
-			s.SendBlockBodies(ch.Peer, hashes)
-		case err := <-getBlockBodiesMsgSub.Err():
-			s.log.Error("getBlockBodiesMsgSub err", "err", err)
-			break
-
-		// 接收响应
-		case blockHeadersMsg := <-respondBlockHeadersMsgCh:
-			var headers []*models.Header
-			if err := codec.Coder().Decode(blockHeadersMsg.Data, &headers); err != nil {
-				s.log.Error("blockHeadersMsg decode err", "msg", blockHeadersMsg, "err", err)
-				break
-			}
-			s.HandleBlockHeadersMsg(blockHeadersMsg.Peer, headers)
-		case err := <-respondBlockHeadersMsgSub.Err():
-			s.log.Error("respondBlockHeadersMsgSub err", "err", err)
-			break
-
-		case blockBodiesMsg := <-respondBlockBodiesMsgCh:
-			var request []*models.Body
-			if err := codec.Coder().Decode(blockBodiesMsg.Data, &request); err != nil {
-				s.log.Error("blockBodiesMsg decode err", "msg", blockBodiesMsg, "err", err)
-				break
+// serveBodies 查询body并按编码版本编码，p2p及mirror共用，同时返回实际找到body的hash
+func (s *syncer) serveBodies(hashes []types.Hash, version CodecVersion) ([]byte, []types.Hash, error) {
+	cacheKey := bodiesPayloadKey(hashes, version)
+	if payload, ok := s.serveCache.payload(cacheKey); ok {
+		// 只缓存完整的结果
+		return payload, hashes, nil
+	}
+	var (
+		body        *models.Body
+		bodies      []*models.Body
+		served      []types.Hash
+		complete    = true
+		found       bool
+		pruned      bool
+		historyFrom = s.HistoryFrom()
+	)
+	for _, blockHash := range hashes {
+		body = s.serveCache.GetBody(blockHash)
+		if body == nil {
+			complete = false
+			if header := s.serveCache.GetHeaderByHash(blockHash); header != nil && header.Height < historyFrom {
+				pruned = true
 			}
-			s.HandleBlockBodiesMsg(blockBodiesMsg.Peer, request)
-		case err := <-respondBlockBodiesMsgSub.Err():
-			s.log.Error("respondBlockBodiesMsgSub err", "err", err)
-			break
-
+		} else {
+			found = true
+			served = append(served, blockHash)
 		}
+		bodies = append(bodies, body)
+	}
+	// 请求的区块都已被裁剪时，明确告知对方
+	if !found && pruned {
+		return nil, nil, fmt.Errorf("%w: from=%d", errOutOfRange, historyFrom)
+	}
+
+	toBytes, err := s.marshal(wireFormat{version: version}, bodies)
+	if err != nil {
+		return nil, nil, err
+	}
+	if complete {
+		s.serveCache.setPayload(cacheKey, toBytes)
 	}
+	return toBytes, served, nil
 }
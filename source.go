@@ -0,0 +1,296 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"strings"
+	"time"
+)
+
+const (
+	p2pSourceName    = "p2p"
+	p2pFetchTimeout  = 10 * time.Second // 单个p2p请求的超时时间
+	p2pFetchMaxPeers = 3                // 单个请求最多尝试的节点个数
+)
+
+var (
+	errNotAvailable = errors.New("data not available from source")
+	errNoSources    = errors.New("no block sources")
+	errFetchTimeout = errors.New("fetch timeout")
+	errStopped      = errors.New("syncer stopped")
+)
+
+// BlockSource 同步的区块来源，可以是p2p网络、本地归档或镜像节点
+type BlockSource interface {
+	// Name 来源名称
+	Name() string
+	// Height 来源可提供的最高区块高度
+	Height(ctx context.Context) (uint64, error)
+	// Headers 从origin开始获取最多amount个header。reverse为true时往创世块方向获取。
+	// origin同时带有Hash及Number时按Hash查询，Number为该区块的预期高度，只用于选择可提供的来源
+	Headers(ctx context.Context, origin ext.HashOrNumber, amount int, reverse bool) ([]*models.Header, error)
+	// Bodies 根据区块hash获取body，返回顺序与hashes一致，不存在的为nil
+	Bodies(ctx context.Context, hashes []types.Hash) ([]*models.Body, error)
+}
+
+// multiSource 组合多个来源，按顺序依次尝试，前一个失败时回退到下一个
+type multiSource struct {
+	sources []BlockSource
+	log     interface {
+		Debug(msg string, ctx ...interface{})
+	}
+}
+
+func (m *multiSource) Name() string {
+	names := make([]string, len(m.sources))
+	for i, source := range m.sources {
+		names[i] = source.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m *multiSource) Height(ctx context.Context) (uint64, error) {
+	var (
+		height  uint64
+		lastErr = errNoSources
+		found   bool
+	)
+	for _, source := range m.sources {
+		h, err := source.Height(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !found || h > height {
+			height, found = h, true
+		}
+	}
+	if !found {
+		return 0, lastErr
+	}
+	return height, nil
+}
+
+// headers 依次尝试各个来源，返回成功的来源名称
+func (m *multiSource) headers(ctx context.Context, origin ext.HashOrNumber, amount int, reverse bool) ([]*models.Header, string, error) {
+	lastErr := errNoSources
+	for _, source := range m.sources {
+		headers, err := source.Headers(ctx, origin, amount, reverse)
+		if err == nil && len(headers) > 0 {
+			return headers, source.Name(), nil
+		}
+		if err == nil {
+			err = errNotAvailable
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		m.log.Debug("Fetch headers from source failed", "source", source.Name(), "err", err)
+		lastErr = err
+	}
+	return nil, "", lastErr
+}
+
+func (m *multiSource) Headers(ctx context.Context, origin ext.HashOrNumber, amount int, reverse bool) ([]*models.Header, error) {
+	headers, _, err := m.headers(ctx, origin, amount, reverse)
+	return headers, err
+}
+
+// heightSource 可利用区块高度选择节点的来源
+type heightSource interface {
+	// bodiesAt 获取body，heights为hashes对应的区块高度，0表示未知
+	bodiesAt(ctx context.Context, hashes []types.Hash, heights []uint64) ([]*models.Body, error)
+}
+
+func (m *multiSource) Bodies(ctx context.Context, hashes []types.Hash) ([]*models.Body, error) {
+	return m.bodies(ctx, hashes, nil)
+}
+
+// bodies 依次尝试各个来源，已获取到的body不会重复请求。heights为空时表示高度都未知
+func (m *multiSource) bodies(ctx context.Context, hashes []types.Hash, heights []uint64) ([]*models.Body, error) {
+	result := make([]*models.Body, len(hashes))
+	missing := make([]int, len(hashes))
+	for i := range hashes {
+		missing[i] = i
+	}
+	for _, source := range m.sources {
+		request := make([]types.Hash, len(missing))
+		requestHeights := make([]uint64, len(missing))
+		for i, index := range missing {
+			request[i] = hashes[index]
+			if heights != nil {
+				requestHeights[i] = heights[index]
+			}
+		}
+		var (
+			bodies []*models.Body
+			err    error
+		)
+		if hs, ok := source.(heightSource); ok {
+			bodies, err = hs.bodiesAt(ctx, request, requestHeights)
+		} else {
+			bodies, err = source.Bodies(ctx, request)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			m.log.Debug("Fetch bodies from source failed", "source", source.Name(), "err", err)
+			continue
+		}
+		var remain []int
+		for i, index := range missing {
+			if i < len(bodies) && bodies[i] != nil {
+				result[index] = bodies[i]
+			} else {
+				remain = append(remain, index)
+			}
+		}
+		if missing = remain; len(missing) == 0 {
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d bodies missing", errNotAvailable, len(missing))
+}
+
+// p2pSource 通过p2p网络从远程节点获取数据，默认的来源
+type p2pSource struct {
+	s *syncer
+}
+
+func (p *p2pSource) Name() string { return p2pSourceName }
+
+func (p *p2pSource) Height(ctx context.Context) (uint64, error) {
+	peer := p.s.peers.BestPeer()
+	if peer == nil {
+		return 0, errNoPeers
+	}
+	_, height := peer.Head()
+	return height, nil
+}
+
+func (p *p2pSource) Headers(ctx context.Context, origin ext.HashOrNumber, amount int, reverse bool) ([]*models.Header, error) {
+	// 按高度正向获取时可拆分为多个请求并发进行
+	if origin.Hash == (types.Hash{}) && !reverse {
+		return p.pipelineHeaders(ctx, origin.Number, amount)
+	}
+	tried := make(map[models.P2PID]bool)
+	for i := 0; i < p2pFetchMaxPeers; i++ {
+		var peer *peer
+		// 按高度或带有预期高度的hash请求时，需要远程节点可提供请求的第一个区块
+		if origin.Hash == (types.Hash{}) || origin.Number != 0 {
+			from, to := origin.Number, origin.Number
+			if reverse {
+				if from+1 > uint64(amount) {
+					from = from + 1 - uint64(amount)
+				} else {
+					from = 0
+				}
+			}
+			peer = p.s.peers.BestPeerFor(from, to, tried)
+		} else {
+			peer = p.s.peers.BestPeerExcept(0, tried)
+		}
+		if peer == nil {
+			break
+		}
+		tried[peer.P2PID] = true
+
+		headers, err := p.fetchHeaders(ctx, peer, origin, amount, reverse)
+		if err == nil && len(headers) > 0 {
+			return headers, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p.s.log.Debug("Fetch headers from peer failed", "peer", peer.P2PID, "err", err, "count", len(headers))
+	}
+	if len(tried) == 0 {
+		return nil, errNoPeers
+	}
+	return nil, errNotAvailable
+}
+
+func (p *p2pSource) fetchHeaders(ctx context.Context, peer *peer, origin ext.HashOrNumber, amount int, reverse bool) ([]*models.Header, error) {
+	// 预期高度只用于选择节点，请求中只带hash
+	if origin.Hash != (types.Hash{}) {
+		origin.Number = 0
+	}
+	req := p.s.fetcher.addHeaders(peer.P2PID, origin)
+	defer p.s.fetcher.remove(req)
+
+	var err error
+	if origin.Hash != (types.Hash{}) {
+		err = p.s.RequestHeadersByHash(peer.P2PID, origin.Hash, amount, 0, reverse)
+	} else {
+		err = p.s.RequestHeadersByNumber(peer.P2PID, origin.Number, amount, 0, reverse)
+	}
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case headers := <-req.headers:
+		return headers, nil
+	case err := <-req.failed:
+		return nil, err
+	case <-p.s.clock.After(peer.throughput.timeout()):
+		return nil, errFetchTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.s.quitCh:
+		return nil, errStopped
+	}
+}
+
+func (p *p2pSource) Bodies(ctx context.Context, hashes []types.Hash) ([]*models.Body, error) {
+	return p.bodiesAt(ctx, hashes, make([]uint64, len(hashes)))
+}
+
+// bodiesAt 未知的高度从下载队列及本地链中查找，已知高度的区块只选择可提供的节点
+func (p *p2pSource) bodiesAt(ctx context.Context, hashes []types.Hash, heights []uint64) ([]*models.Body, error) {
+	var unknown []int
+	for i, height := range heights {
+		if height == 0 {
+			unknown = append(unknown, i)
+		}
+	}
+	if len(unknown) > 0 {
+		heights = append([]uint64(nil), heights...)
+		lookup := make([]types.Hash, len(unknown))
+		for i, index := range unknown {
+			lookup[i] = hashes[index]
+		}
+		for i, height := range p.s.heightsOf(lookup) {
+			heights[unknown[i]] = height
+		}
+	}
+	return p.pipelineBodies(ctx, hashes, heights)
+}
+
+func (p *p2pSource) fetchBodies(ctx context.Context, peer *peer, hashes []types.Hash, heights []uint64) ([]*models.Body, error) {
+	req := p.s.fetcher.addBodies(peer.P2PID, hashes, heights)
+	defer p.s.fetcher.remove(req)
+
+	if err := p.s.RequestBlockBodies(peer.P2PID, hashes); err != nil {
+		return nil, err
+	}
+	select {
+	case bodies := <-req.bodies:
+		return bodies, nil
+	case err := <-req.failed:
+		return nil, err
+	case <-p.s.clock.After(peer.throughput.timeout()):
+		return nil, errFetchTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.s.quitCh:
+		return nil, errStopped
+	}
+}
@@ -0,0 +1,262 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const archiveSourceCache = 2 * 192 // 缓存最近读取的区块数，供随后的body请求使用
+
+// archiveFile 归档目录中的单个文件
+type archiveFile struct {
+	path     string
+	header   *archiveHeader
+	verified bool // checksum是否已校验
+
+	file   *os.File       // 顺序读取中的文件，可为空
+	reader *archiveBlocks // 上次读取的位置，按高度递增读取时从该位置继续
+}
+
+// seek 返回下一个区块不高于from的读取位置，from在上次位置之前时重新打开文件
+func (f *archiveFile) seek(from uint64) (*archiveBlocks, error) {
+	if f.reader != nil && f.reader.next <= from {
+		return f.reader, nil
+	}
+	f.close()
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decodeArchiveHeader(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := newArchiveBlocks(file, f.header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.file, f.reader = file, reader
+	return reader, nil
+}
+
+func (f *archiveFile) close() {
+	if f.reader != nil {
+		f.reader.close()
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.reader = nil, nil
+}
+
+// archiveDirSource 从本地归档目录中读取区块
+type archiveDirSource struct {
+	dir    string
+	files  []*archiveFile // 按起始高度排序
+	blocks *lruCache      // hash==>*models.Block
+	lock   sync.Mutex
+}
+
+// NewArchiveSource 创建本地归档目录的区块来源，目录中的归档文件由ExportChain生成，
+// 需属于networkId及genesis对应的链
+func NewArchiveSource(dir string, networkId uint64, genesis types.Hash) (BlockSource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	source := &archiveDirSource{
+		dir:    dir,
+		blocks: newLRUCache(archiveSourceCache),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		header, err := readArchiveFileHeader(path)
+		if err != nil {
+			// 忽略非归档文件
+			continue
+		}
+		if header.NetworkId != networkId || header.GenesisHash != genesis {
+			return nil, fmt.Errorf("%w: %s network=%d genesis=%s", errArchiveChain, path, header.NetworkId, header.GenesisHash.Hex())
+		}
+		source.files = append(source.files, &archiveFile{path: path, header: header})
+	}
+	sort.Slice(source.files, func(i, j int) bool {
+		return source.files[i].header.From < source.files[j].header.From
+	})
+	return source, nil
+}
+
+func readArchiveFileHeader(path string) (*archiveHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeArchiveHeader(f)
+}
+
+func (a *archiveDirSource) Name() string { return "archive:" + a.dir }
+
+func (a *archiveDirSource) Height(ctx context.Context) (uint64, error) {
+	if len(a.files) == 0 {
+		return 0, errNotAvailable
+	}
+	var height uint64
+	for _, file := range a.files {
+		if file.header.To > height {
+			height = file.header.To
+		}
+	}
+	return height, nil
+}
+
+// Headers 按高度查询。按hash查询时先查找最近读取过的区块，未找到时按预期高度读取后校验hash
+func (a *archiveDirSource) Headers(ctx context.Context, origin ext.HashOrNumber, amount int, reverse bool) ([]*models.Header, error) {
+	if amount <= 0 {
+		return nil, nil
+	}
+	number := origin.Number
+	if origin.Hash != (types.Hash{}) {
+		if block, ok := a.blocks.Get(origin.Hash); ok {
+			number = block.(*models.Block).Height()
+		} else if number == 0 {
+			return nil, errNotAvailable
+		}
+	}
+	from, to := number, number+uint64(amount)-1
+	if reverse {
+		from = 0
+		if number+1 > uint64(amount) {
+			from = number + 1 - uint64(amount)
+		}
+		to = number
+	}
+	blocks, err := a.readRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]*models.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	// 按预期高度读取时，起点区块的hash需一致
+	if origin.Hash != (types.Hash{}) {
+		found := false
+		for _, header := range headers {
+			if header.Height == number {
+				found = header.Hash() == origin.Hash
+				break
+			}
+		}
+		if !found {
+			return nil, errNotAvailable
+		}
+	}
+	if reverse {
+		for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+			headers[i], headers[j] = headers[j], headers[i]
+		}
+	}
+	return headers, nil
+}
+
+func (a *archiveDirSource) Bodies(ctx context.Context, hashes []types.Hash) ([]*models.Body, error) {
+	bodies := make([]*models.Body, len(hashes))
+	for i, hash := range hashes {
+		if block, ok := a.blocks.Get(hash); ok {
+			bodies[i] = block.(*models.Block).Body()
+		}
+	}
+	return bodies, nil
+}
+
+// readRange 读取[from,to]区间内的连续区块，只读取包含from的单个文件。
+// 同步时按高度递增读取，从上次的位置继续，不必每次从文件开头解码
+func (a *archiveDirSource) readRange(from, to uint64) ([]*models.Block, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var file *archiveFile
+	for _, f := range a.files {
+		if f.header.From <= from && from <= f.header.To {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		return nil, errNotAvailable
+	}
+	if !file.verified {
+		if err := verifyArchiveFile(file); err != nil {
+			return nil, err
+		}
+		file.verified = true
+	}
+
+	// 同一时间只保持一个文件的读取位置
+	for _, f := range a.files {
+		if f != file {
+			f.close()
+		}
+	}
+	reader, err := file.seek(from)
+	if err != nil {
+		return nil, err
+	}
+	// 跳过from之前的区块，不解码
+	for reader.next < from {
+		if _, err := reader.item(); err != nil {
+			file.close()
+			return nil, err
+		}
+	}
+	var blocks []*models.Block
+	for reader.next <= to {
+		block, err := reader.block()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.close()
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		a.blocks.Add(block.Hash(), block)
+	}
+	return blocks, nil
+}
+
+func verifyArchiveFile(file *archiveFile) error {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := decodeArchiveHeader(f); err != nil {
+		return err
+	}
+	checksum := sha256.New()
+	if err := readArchiveBlocks(f, file.header, checksum, nil); err != nil {
+		return err
+	}
+	if types.BytesToHash(checksum.Sum(nil)) != file.header.Checksum {
+		return fmt.Errorf("%w: %s", errArchiveChecksum, file.path)
+	}
+	return nil
+}
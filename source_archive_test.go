@@ -0,0 +1,93 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveSourceRejectsOtherChain(t *testing.T) {
+	source, _ := testArchiveChain(2)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1-2.archive"), exportTestArchive(t, source, 2, false), 0o644); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	genesis := source.GetHeaderByNumber(0).Hash()
+
+	tests := []struct {
+		name      string
+		networkId uint64
+		genesis   types.Hash
+		err       error
+	}{
+		{name: "same chain", genesis: genesis},
+		{name: "network id", networkId: 8, genesis: genesis, err: errArchiveChain},
+		{name: "genesis", genesis: types.Hash{1}, err: errArchiveChain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewArchiveSource(dir, tt.networkId, tt.genesis); !errors.Is(err, tt.err) {
+				t.Fatalf("NewArchiveSource err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestArchiveSourceReadsForward(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%t", compress), func(t *testing.T) {
+			chain, blocks := testArchiveChain(6)
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "1-6.archive"), exportTestArchive(t, chain, 6, compress), 0o644); err != nil {
+				t.Fatalf("write archive: %v", err)
+			}
+			source, err := NewArchiveSource(dir, 0, chain.GetHeaderByNumber(0).Hash())
+			if err != nil {
+				t.Fatalf("open archive source: %v", err)
+			}
+			file := source.(*archiveDirSource).files[0]
+
+			// 递增读取时从上次的位置继续，往回读取时重新打开文件
+			var reader *archiveBlocks
+			for _, tt := range []struct {
+				from     uint64
+				amount   int
+				reopened bool
+			}{
+				{from: 1, amount: 2, reopened: true},
+				{from: 3, amount: 2},
+				{from: 6, amount: 3},
+				{from: 2, amount: 2, reopened: true},
+			} {
+				headers, err := source.Headers(context.Background(), ext.HashOrNumber{Number: tt.from}, tt.amount, false)
+				if err != nil {
+					t.Fatalf("headers from %d: %v", tt.from, err)
+				}
+				want := blocks[tt.from-1:]
+				if len(want) > tt.amount {
+					want = want[:tt.amount]
+				}
+				if len(headers) != len(want) {
+					t.Fatalf("headers from %d: got %d, want %d", tt.from, len(headers), len(want))
+				}
+				for i, header := range headers {
+					if header.Hash() != want[i].Hash() {
+						t.Fatalf("header %d mismatch", header.Height)
+					}
+				}
+				if reopened := file.reader != reader; reopened != tt.reopened {
+					t.Fatalf("read from %d reopened = %t, want %t", tt.from, reopened, tt.reopened)
+				}
+				reader = file.reader
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 镜像节点的http接口，请求与响应均使用codec编码
+const (
+	mirrorHeadPath    = "/head"    // GET，返回最高区块高度
+	mirrorHeadersPath = "/headers" // GET ?hash=|number=&amount=&reverse=
+	mirrorBodiesPath  = "/bodies"  // POST 区块hash列表
+
+	maxMirrorResponseSize = 32 * 1024 * 1024
+)
+
+// httpSource 从http镜像节点获取区块
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSource 创建http镜像节点的区块来源，client为空时使用http.DefaultClient
+func NewHTTPSource(baseURL string, client *http.Client) BlockSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSource{
+		url:    strings.TrimRight(baseURL, "/"),
+		client: client,
+	}
+}
+
+func (h *httpSource) Name() string { return "http:" + h.url }
+
+func (h *httpSource) Height(ctx context.Context) (uint64, error) {
+	var height uint64
+	if err := h.do(ctx, http.MethodGet, h.url+mirrorHeadPath, nil, &height); err != nil {
+		return 0, err
+	}
+	return height, nil
+}
+
+func (h *httpSource) Headers(ctx context.Context, origin ext.HashOrNumber, amount int, reverse bool) ([]*models.Header, error) {
+	query := url.Values{}
+	if origin.Hash != (types.Hash{}) {
+		query.Set("hash", origin.Hash.Hex())
+	} else {
+		query.Set("number", strconv.FormatUint(origin.Number, 10))
+	}
+	query.Set("amount", strconv.Itoa(amount))
+	query.Set("reverse", strconv.FormatBool(reverse))
+
+	var headers []*models.Header
+	if err := h.do(ctx, http.MethodGet, h.url+mirrorHeadersPath+"?"+query.Encode(), nil, &headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+func (h *httpSource) Bodies(ctx context.Context, hashes []types.Hash) ([]*models.Body, error) {
+	data, err := codec.Coder().Encode(hashes)
+	if err != nil {
+		return nil, err
+	}
+	var bodies []*models.Body
+	if err := h.do(ctx, http.MethodPost, h.url+mirrorBodiesPath, data, &bodies); err != nil {
+		return nil, err
+	}
+	return bodies, nil
+}
+
+func (h *httpSource) do(ctx context.Context, method, target string, body []byte, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotAvailable
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mirror response status: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMirrorResponseSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxMirrorResponseSize {
+		return fmt.Errorf("mirror response exceeds %d bytes", maxMirrorResponseSize)
+	}
+	return codec.Coder().Decode(data, result)
+}
+
+// MirrorHandler 以http方式对外提供区块数据，供其他节点通过NewHTTPSource同步
+func (s *syncer) MirrorHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(mirrorHeadPath, func(w http.ResponseWriter, r *http.Request) {
+		data, err := codec.Coder().Encode(s.localHeight())
+		writeMirrorResponse(w, data, err)
+	})
+	mux.HandleFunc(mirrorHeadersPath, func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseMirrorHeadersQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := s.serveHeaders(query, CodecRLP)
+		writeMirrorResponse(w, data, err)
+	})
+	mux.HandleFunc(mirrorBodiesPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, int64(MaxBodyFetch*types.HashLength*2)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var hashes []types.Hash
+		if err := codec.Coder().Decode(body, &hashes); err != nil || len(hashes) > MaxBodyFetch {
+			http.Error(w, "invalid hashes", http.StatusBadRequest)
+			return
+		}
+		data, _, err := s.serveBodies(hashes, CodecRLP)
+		writeMirrorResponse(w, data, err)
+	})
+	return mux
+}
+
+func parseMirrorHeadersQuery(values url.Values) (ext.GetBlockHeadersData, error) {
+	var (
+		query ext.GetBlockHeadersData
+		err   error
+	)
+	if hash := values.Get("hash"); hash != "" {
+		query.Origin.Hash = types.HexToHash(hash)
+	} else if query.Origin.Number, err = strconv.ParseUint(values.Get("number"), 10, 64); err != nil {
+		return query, fmt.Errorf("invalid number: %v", err)
+	}
+	if query.Amount, err = strconv.ParseUint(values.Get("amount"), 10, 64); err != nil {
+		return query, fmt.Errorf("invalid amount: %v", err)
+	}
+	if reverse := values.Get("reverse"); reverse != "" {
+		if query.Reverse, err = strconv.ParseBool(reverse); err != nil {
+			return query, fmt.Errorf("invalid reverse: %v", err)
+		}
+	}
+	return query, nil
+}
+
+func writeMirrorResponse(w http.ResponseWriter, data []byte, err error) {
+	if errors.Is(err, errOutOfRange) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
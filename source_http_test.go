@@ -0,0 +1,105 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestMirror 以chain的区块启动http镜像节点
+func newTestMirror(t *testing.T, chain *testChain) *httptest.Server {
+	s, _ := newTestSyncer(t, chain)
+	server := httptest.NewServer(s.MirrorHandler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestStatusServer 所有请求都返回status的http服务
+func newTestStatusServer(t *testing.T, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMultiSourceFallback(t *testing.T) {
+	short, long := newTestChain(), newTestChain()
+	headers := testHeaders(short.GetHeaderByNumber(0), 5, 0)
+	short.extend(headers[:3])
+	long.extend(headers)
+
+	var (
+		down    = newTestStatusServer(t, http.StatusInternalServerError)
+		missing = newTestStatusServer(t, http.StatusNotFound)
+		mirrorA = newTestMirror(t, short)
+		mirrorB = newTestMirror(t, long)
+	)
+	tests := []struct {
+		name    string
+		servers []*httptest.Server
+		height  uint64
+		source  string
+		fail    bool
+	}{
+		{name: "first available", servers: []*httptest.Server{mirrorA, down}, height: 3, source: mirrorA.URL},
+		{name: "server error", servers: []*httptest.Server{down, mirrorA}, height: 3, source: mirrorA.URL},
+		{name: "not available", servers: []*httptest.Server{missing, mirrorB}, height: 5, source: mirrorB.URL},
+		{name: "highest source", servers: []*httptest.Server{mirrorA, down, mirrorB}, height: 5, source: mirrorA.URL},
+		{name: "all failed", servers: []*httptest.Server{down, missing}, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &multiSource{log: testLogger{}}
+			for _, server := range tt.servers {
+				m.sources = append(m.sources, NewHTTPSource(server.URL, server.Client()))
+			}
+			ctx := context.Background()
+
+			height, err := m.Height(ctx)
+			if (err != nil) != tt.fail || height != tt.height {
+				t.Fatalf("height = %d err = %v, want %d", height, err, tt.height)
+			}
+
+			got, source, err := m.headers(ctx, ext.HashOrNumber{Number: 1}, 3, false)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("headers from %s, want error", source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("headers err: %v", err)
+			}
+			if source != "http:"+tt.source {
+				t.Fatalf("headers source = %s, want %s", source, tt.source)
+			}
+			if len(got) != 3 {
+				t.Fatalf("got %d headers, want 3", len(got))
+			}
+			hashes := make([]types.Hash, len(got))
+			for i, header := range got {
+				if header.Hash() != headers[i].Hash() {
+					t.Fatalf("header %d mismatch", header.Height)
+				}
+				hashes[i] = header.Hash()
+			}
+
+			bodies, err := m.Bodies(ctx, hashes)
+			if err != nil {
+				t.Fatalf("bodies err: %v", err)
+			}
+			for i, body := range bodies {
+				if body == nil || body.Height != headers[i].Height {
+					t.Fatalf("body %d = %v, want height %d", i, body, headers[i].Height)
+				}
+			}
+		})
+	}
+}
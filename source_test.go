@@ -0,0 +1,89 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"context"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"testing"
+	"time"
+)
+
+// requestedPeers 发送队列中有code请求的节点
+func requestedPeers(peers []*peer, code uint) []models.P2PID {
+	var ids []models.P2PID
+	for _, p := range peers {
+		for msg := p.queue.pop(); msg != nil; msg = p.queue.pop() {
+			if msg.Type == code {
+				ids = append(ids, p.P2PID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+func TestP2PSourceHonoursHistoryFrom(t *testing.T) {
+	hash := types.Hash{1}
+	// 两个节点链头相同，只有b-archive可提供高度50的区块
+	tests := []struct {
+		name  string
+		fetch func(ctx context.Context, p *p2pSource)
+		code  uint
+		want  models.P2PID
+	}{
+		{
+			name: "headers by hash with height",
+			fetch: func(ctx context.Context, p *p2pSource) {
+				p.Headers(ctx, ext.HashOrNumber{Hash: hash, Number: 50}, 10, true)
+			},
+			code: GetBlockHeadersMsg,
+			want: "b-archive",
+		},
+		{
+			name: "bodies with height",
+			fetch: func(ctx context.Context, p *p2pSource) {
+				p.bodiesAt(ctx, []types.Hash{hash}, []uint64{50})
+			},
+			code: GetBlockBodiesMsg,
+			want: "b-archive",
+		},
+		{
+			name: "bodies with unknown height",
+			fetch: func(ctx context.Context, p *p2pSource) {
+				p.Bodies(ctx, []types.Hash{hash})
+			},
+			code: GetBlockBodiesMsg,
+			want: "b-archive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSyncer(t, newTestChain())
+			defer s.Stop()
+
+			// 不启动发送协程，请求留在发送队列中
+			var peers []*peer
+			for _, id := range []models.P2PID{"a-pruned", "b-archive"} {
+				p := newPeer(s.p2p, id)
+				p.SetChainHead(ChainHead{Hash: types.Hash{2}, Height: 200})
+				if id == "a-pruned" {
+					p.SetHistoryFrom(100)
+				}
+				s.peers.Register(p, nil)
+				peers = append(peers, p)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			tt.fetch(ctx, &p2pSource{s: s})
+
+			if got := requestedPeers(peers, tt.code); len(got) != 1 || got[0] != tt.want {
+				t.Fatalf("requested from %v, want only %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"time"
+)
+
+// syncStateKey 同步状态在kv存储中的key
+var syncStateKey = []byte("chain5j-syncer-state")
+
+// persistedState 需跨重启保存的同步状态
+type persistedState struct {
+	TargetHeight uint64     // 同步目标高度
+	TargetHash   types.Hash // 同步目标hash
+
+	HasCheckpoint  bool       // 是否设置了可信检查点
+	Checkpoint     Checkpoint // 可信检查点
+	BackfillNext   types.Hash // 回填的下一批起点
+	BackfillHeight uint64     // 回填的下一批起点高度
+	BackfillDone   bool       // 是否已回填完成
+
+	BannedPeers []persistedBan // 被禁止的节点
+	BadBlocks   []BadBlock     // 已知的无效区块
+}
+
+type persistedBan struct {
+	Peer  models.P2PID
+	Until uint64 // 解禁时间，unix秒
+}
+
+// saveState 保存同步状态，未设置存储时忽略
+func (s *syncer) saveState() {
+	if s.stateStore == nil {
+		return
+	}
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	state := new(persistedState)
+	s.control.lock.RLock()
+	state.TargetHeight, state.TargetHash = s.control.targetHeight, s.control.targetHash
+	s.control.lock.RUnlock()
+
+	if b := s.backfill; b != nil {
+		b.lock.RLock()
+		state.HasCheckpoint, state.Checkpoint = true, b.checkpoint
+		state.BackfillNext, state.BackfillHeight, state.BackfillDone = b.next, b.nextHeight, b.done
+		b.lock.RUnlock()
+	}
+	for id, until := range s.bans.list() {
+		state.BannedPeers = append(state.BannedPeers, persistedBan{Peer: id, Until: uint64(until.Unix())})
+	}
+	state.BadBlocks = s.BadBlocks()
+
+	data, err := codec.Coder().Encode(state)
+	if err != nil {
+		s.log.Error("sync state codec.Encode err", "err", err)
+		return
+	}
+	if err := s.stateStore.Put(syncStateKey, data); err != nil {
+		s.log.Error("save sync state err", "err", err)
+	}
+}
+
+// loadState 启动时恢复上次保存的同步状态，通过option设置的目标及检查点优先
+func (s *syncer) loadState() error {
+	if s.stateStore == nil {
+		return nil
+	}
+	if ok, err := s.stateStore.Has(syncStateKey); err != nil || !ok {
+		return err
+	}
+	data, err := s.stateStore.Get(syncStateKey)
+	if err != nil {
+		return err
+	}
+	// 无法解码的状态直接忽略，不影响启动，下次保存时覆盖
+	state := new(persistedState)
+	if err := codec.Coder().Decode(data, state); err != nil {
+		s.log.Warn("ignore corrupt sync state", "err", err)
+		return nil
+	}
+
+	s.control.lock.Lock()
+	if s.control.targetHeight == 0 && state.TargetHeight > 0 {
+		s.control.targetHeight, s.control.targetHash = state.TargetHeight, state.TargetHash
+	}
+	s.control.lock.Unlock()
+
+	if state.HasCheckpoint && s.mode == FullSync {
+		if s.backfill == nil {
+			s.backfill = newBackfill(state.Checkpoint)
+		}
+		// 检查点未变化时从上次的位置继续回填
+		if b := s.backfill; b.checkpoint == state.Checkpoint && state.BackfillNext != (types.Hash{}) {
+			b.lock.Lock()
+			b.next, b.nextHeight, b.done = state.BackfillNext, state.BackfillHeight, state.BackfillDone
+			b.anchored = b.done || b.nextHeight < b.checkpoint.Height
+			b.lock.Unlock()
+		}
+	}
+
+	now := s.now()
+	for _, ban := range state.BannedPeers {
+		if until := time.Unix(int64(ban.Until), 0); until.After(now) {
+			s.bans.ban(ban.Peer, until)
+		}
+	}
+	// Keys按最近使用排列，倒序添加以保持顺序
+	for i := len(state.BadBlocks) - 1; i >= 0; i-- {
+		bad := state.BadBlocks[i]
+		s.badBlocks.Add(bad.Hash, &bad)
+	}
+	s.log.Info("Restored sync state", "target", state.TargetHeight, "checkpoint", state.Checkpoint.Height,
+		"backfill", state.BackfillHeight, "banned", len(state.BannedPeers), "badBlocks", len(state.BadBlocks))
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore 内存kv存储
+type memStore struct {
+	data map[string][]byte
+	lock sync.Mutex
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (m *memStore) Has(key []byte) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, ok := m.data[string(key)]
+	return ok, nil
+}
+
+func (m *memStore) Get(key []byte) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.data[string(key)], nil
+}
+
+func (m *memStore) Put(key []byte, value []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *memStore) Delete(key []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.data, string(key))
+	return nil
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	store := newMemStore()
+	checkpoint := Checkpoint{Height: 100, Hash: types.Hash{1}}
+	saved, _ := newTestSyncer(t, newTestChain(), WithStateStore(store), WithCheckpoint(checkpoint))
+	saved.backfill.lock.Lock()
+	saved.backfill.next, saved.backfill.nextHeight = types.Hash{2}, 60
+	saved.backfill.lock.Unlock()
+	saved.bans.ban("banned", saved.now().Add(banDuration))
+	saved.bans.ban("expired", saved.now().Add(-time.Second))
+	saved.markBadBlock(types.Hash{3}, 7, "invalid", "remote")
+	saved.setTarget(9, types.Hash{4})
+	saved.Stop()
+
+	s, _ := newTestSyncer(t, newTestChain(), WithStateStore(store))
+	defer s.Stop()
+	if err := s.loadState(); err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if s.control.targetHeight != 9 || s.control.targetHash != (types.Hash{4}) {
+		t.Fatalf("target = %d %s, want 9 %s", s.control.targetHeight, s.control.targetHash, types.Hash{4})
+	}
+	if s.backfill == nil || s.backfill.checkpoint != checkpoint {
+		t.Fatalf("checkpoint not restored")
+	}
+	if s.backfill.next != (types.Hash{2}) || s.backfill.nextHeight != 60 || !s.backfill.anchored {
+		t.Fatalf("backfill = %s %d anchored=%t, want %s 60 anchored", s.backfill.next, s.backfill.nextHeight, s.backfill.anchored, types.Hash{2})
+	}
+	for id, want := range map[models.P2PID]bool{"banned": true, "expired": false} {
+		if banned := s.bans.banned(id); banned != want {
+			t.Fatalf("%s banned = %t, want %t", id, banned, want)
+		}
+	}
+	if bad := s.BadBlocks(); len(bad) != 1 || bad[0].Hash != (types.Hash{3}) || bad[0].Height != 7 || bad[0].Reason != "invalid" {
+		t.Fatalf("bad blocks = %+v", bad)
+	}
+}
+
+func TestCorruptStateIgnored(t *testing.T) {
+	store := newMemStore()
+	store.Put(syncStateKey, []byte{0xff, 0x01, 0x02})
+
+	s, p2p := newTestSyncer(t, newTestChain(), WithStateStore(store))
+	if err := s.Start(); err != nil {
+		t.Fatalf("start with corrupt state: %v", err)
+	}
+	defer s.Stop()
+	waitSubscribed(t, s, p2p)
+
+	// 下次保存时覆盖无法解码的状态
+	if err := s.SyncTo(5); err != nil {
+		t.Fatalf("sync to: %v", err)
+	}
+	restored, _ := newTestSyncer(t, newTestChain(), WithStateStore(store))
+	defer restored.Stop()
+	if err := restored.loadState(); err != nil || restored.control.targetHeight != 5 {
+		t.Fatalf("load overwritten state err = %v, target = %d", err, restored.control.targetHeight)
+	}
+}
@@ -0,0 +1,245 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"math/big"
+)
+
+// syncProtocolVersion 同步协议版本
+const syncProtocolVersion = 1
+
+// 同步能力
+const (
+	CapServeHeaders uint64 = 1 << iota // 可提供header
+	CapServeBodies                     // 可提供body
+	CapSnappy                          // 支持snappy压缩
+	CapCodecVersion                    // 消息带编码版本号
+)
+
+const defaultCapabilities = CapServeHeaders | CapServeBodies | CapCodecVersion
+
+var (
+	errNetworkIdMismatch       = errors.New("network id mismatch")
+	errGenesisMismatch         = errors.New("genesis block mismatch")
+	errProtocolVersionMismatch = errors.New("sync protocol version mismatch")
+)
+
+// statusData 同步层的状态信息，在handshake之后交换，用于确认双方在同一条链上
+type statusData struct {
+	ProtocolVersion uint32     // 同步协议版本
+	NetworkId       uint64     // 网络ID
+	GenesisHash     types.Hash // 创世块hash
+	CurrentHash     types.Hash // 当前区块hash
+	CurrentHeight   uint64     // 当前区块高度
+	Capabilities    uint64     // 支持的能力
+	Weight          *big.Int   // 链权重，0表示未知
+	HistoryFrom     uint64     `rlp:"optional"` // 可提供完整区块的最低高度
+	Codecs          []uint8    `rlp:"optional"` // 支持的编码版本号
+}
+
+// statusMsg 来自远程节点的状态
+type statusMsg struct {
+	peer   models.P2PID
+	status *statusData
+	done   chan struct{} // 处理完成后关闭，可为空
+}
+
+// localStatus 本地节点的状态
+func (s *syncer) localStatus() *statusData {
+	status := &statusData{
+		ProtocolVersion: syncProtocolVersion,
+		NetworkId:       s.networkId,
+		GenesisHash:     s.genesisHash(),
+		Capabilities:    defaultCapabilities | s.compression.capabilities(),
+		HistoryFrom:     s.HistoryFrom(),
+		Codecs:          s.codecs.versions(),
+	}
+	head := s.localHead()
+	status.CurrentHash, status.CurrentHeight = head.Hash, head.Height
+	status.Weight = head.Weight
+	if status.Weight == nil {
+		status.Weight = new(big.Int)
+	}
+	if s.mode == LightSync {
+		// 轻节点没有body，只能提供header
+		status.Capabilities &^= CapServeBodies
+	}
+	return status
+}
+
+// genesisHash 本地创世块hash
+func (s *syncer) genesisHash() types.Hash {
+	if s.genesis != (types.Hash{}) {
+		return s.genesis
+	}
+	var genesis *models.Header
+	if s.mode == LightSync {
+		genesis = s.headerStore.GetHeaderByNumber(0)
+	} else {
+		genesis = s.blockRW.GetHeaderByNumber(0)
+	}
+	if genesis != nil {
+		s.genesis = genesis.Hash()
+	}
+	return s.genesis
+}
+
+// sendStatus 向远程节点发送本地状态
+func (s *syncer) sendStatus(peerId models.P2PID) {
+	msg, err := s.statusMessage()
+	if err != nil {
+		s.log.Error("status codec.Encode err", "err", err)
+		return
+	}
+	if err := s.send(peerId, msg); err != nil {
+		s.log.Error("send status err", "peer", peerId, "err", err)
+	}
+}
+
+// replyStatus 回复对方先发起的状态交换。在节点注册前放入其发送队列，
+// 保证对方先收到本地状态，再收到按协商的编码及压缩发送的消息
+func (s *syncer) replyStatus(peer *peer) {
+	msg, err := s.statusMessage()
+	if err != nil {
+		s.log.Error("status codec.Encode err", "err", err)
+		return
+	}
+	// 回放时与send一致，同步发送
+	if s.replaySteps != nil {
+		err = s.p2p.Send(peer.P2PID, msg)
+	} else {
+		err = peer.queue.push(msg)
+	}
+	if err != nil {
+		s.log.Error("send status err", "peer", peer.P2PID, "err", err)
+	}
+}
+
+// statusMessage 本地状态消息，始终使用不带版本号的RLP
+func (s *syncer) statusMessage() (*models.P2PMessage, error) {
+	bytes, err := codec.Coder().Encode(s.localStatus())
+	if err != nil {
+		return nil, err
+	}
+	return &models.P2PMessage{
+		Type: StatusMsg,
+		Peer: "",
+		Data: bytes,
+	}, nil
+}
+
+// checkHandshake 在交换状态前，先用handshake中的信息做一次快速检查
+func (s *syncer) checkHandshake(msg *models.HandshakeMsg) error {
+	if s.networkId != 0 && msg.NetworkId != 0 && msg.NetworkId != s.networkId {
+		return fmt.Errorf("%w: local=%d remote=%d", errNetworkIdMismatch, s.networkId, msg.NetworkId)
+	}
+	if genesis := s.genesisHash(); msg.GenesisBlockHash != (types.Hash{}) && msg.GenesisBlockHash != genesis {
+		return fmt.Errorf("%w: local=%s remote=%s", errGenesisMismatch, genesis.Hex(), msg.GenesisBlockHash.Hex())
+	}
+	return nil
+}
+
+// checkStatus 校验远程节点的状态，不兼容的节点不允许进入peerSet
+func (s *syncer) checkStatus(status *statusData) error {
+	if status.ProtocolVersion != syncProtocolVersion {
+		return fmt.Errorf("%w: local=%d remote=%d", errProtocolVersionMismatch, syncProtocolVersion, status.ProtocolVersion)
+	}
+	if status.NetworkId != s.networkId {
+		return fmt.Errorf("%w: local=%d remote=%d", errNetworkIdMismatch, s.networkId, status.NetworkId)
+	}
+	if genesis := s.genesisHash(); status.GenesisHash != genesis {
+		return fmt.Errorf("%w: local=%s remote=%s", errGenesisMismatch, genesis.Hex(), status.GenesisHash.Hex())
+	}
+	return nil
+}
+
+// handleHandshake 处理handshake事件。已注册的节点直接更新head，
+// 新节点需要先交换状态，校验通过后才会注册
+func (s *syncer) handleHandshake(msg *models.HandshakeMsg) {
+	if s.bans.banned(msg.Peer) {
+		s.dropPeer(msg.Peer)
+		return
+	}
+	if peer := s.peers.Peer(msg.Peer); peer != nil {
+		s.updatePeerHead(peer, ChainHead{Hash: msg.CurrentBlockHash, Height: msg.CurrentBlockHeight})
+		return
+	}
+	if err := s.checkHandshake(msg); err != nil {
+		s.rejectPeer(msg.Peer, err)
+		return
+	}
+	s.pendingStatus[msg.Peer] = true
+	s.sendStatus(msg.Peer)
+}
+
+// handleStatus 处理远程节点的状态，协商的编码及压缩算法在返回前生效
+func (s *syncer) handleStatus(msg *statusMsg) {
+	if msg.done != nil {
+		defer close(msg.done)
+	}
+	defer s.recoverPanic(msg.peer, StatusMsg, nil)
+
+	// 被禁止的节点可能跳过handshake直接发送状态
+	if s.bans.banned(msg.peer) {
+		delete(s.pendingStatus, msg.peer)
+		s.dropPeer(msg.peer)
+		return
+	}
+	if err := s.checkStatus(msg.status); err != nil {
+		delete(s.pendingStatus, msg.peer)
+		s.rejectPeer(msg.peer, err)
+		return
+	}
+	head := ChainHead{
+		Hash:   msg.status.CurrentHash,
+		Height: msg.status.CurrentHeight,
+		Weight: msg.status.Weight,
+	}
+	peer := s.peers.Peer(msg.peer)
+	if peer != nil {
+		// 已注册节点的状态为链头及可提供范围的更新
+		s.updatePeerHead(peer, head)
+		peer.SetHistoryFrom(msg.status.HistoryFrom)
+		peer.SetCompression(s.negotiateCompression(msg.status.Capabilities))
+		peer.SetWireFormat(s.negotiateCodec(msg.status))
+		return
+	}
+	peer = newPeer(s.p2p, msg.peer)
+	peer.SetCompression(s.negotiateCompression(msg.status.Capabilities))
+	peer.SetWireFormat(s.negotiateCodec(msg.status))
+	peer.setSyncSource(s.policy.allowed(msg.peer))
+	// 对方先发起的状态交换，需要回复本地状态
+	if !s.pendingStatus[msg.peer] {
+		s.replyStatus(peer)
+	}
+	delete(s.pendingStatus, msg.peer)
+	if err := s.peers.Register(peer, peer.sendLoop); err != nil {
+		s.log.Error("register peer err", "peer", msg.peer, "err", err)
+		return
+	}
+	s.updatePeerHead(peer, head)
+	peer.SetHistoryFrom(msg.status.HistoryFrom)
+
+	go s.syncBlocksLoop(peer)
+}
+
+// rejectPeer 拒绝不兼容的节点，并在一段时间内禁止其连接
+func (s *syncer) rejectPeer(peerId models.P2PID, err error) {
+	s.log.Warn("reject incompatible peer", "peer", peerId, "err", err)
+	s.bans.ban(peerId, s.now().Add(banDuration))
+	s.saveState()
+	s.dropPeer(peerId)
+}
+
+func (s *syncer) dropPeer(peerId models.P2PID) {
+	if err := s.p2p.DropPeer(peerId); err != nil {
+		s.log.Debug("drop peer err", "peer", peerId, "err", err)
+	}
+}
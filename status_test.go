@@ -0,0 +1,174 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestHandleStatusRejectsIncompatiblePeers(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(status *statusData)
+		err    error
+	}{
+		{name: "compatible", modify: func(status *statusData) {}},
+		{name: "protocol version", modify: func(status *statusData) { status.ProtocolVersion++ }, err: errProtocolVersionMismatch},
+		{name: "network id", modify: func(status *statusData) { status.NetworkId++ }, err: errNetworkIdMismatch},
+		{name: "genesis", modify: func(status *statusData) { status.GenesisHash = types.Hash{1} }, err: errGenesisMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSyncer(t, newTestChain(), WithNetworkId(7))
+			defer s.Stop()
+
+			const id = models.P2PID("remote")
+			status := s.localStatus()
+			status.Weight = new(big.Int)
+			tt.modify(status)
+
+			if err := s.checkStatus(status); !errors.Is(err, tt.err) {
+				t.Fatalf("checkStatus err = %v, want %v", err, tt.err)
+			}
+			s.handleStatus(&statusMsg{peer: id, status: status})
+
+			rejected := tt.err != nil
+			if registered := s.peers.Peer(id) != nil; registered == rejected {
+				t.Fatalf("registered = %t, want %t", registered, !rejected)
+			}
+			if banned := s.bans.banned(id); banned != rejected {
+				t.Fatalf("banned = %t, want %t", banned, rejected)
+			}
+			if _, ok := s.bans.list()[id]; ok != rejected {
+				t.Fatalf("listed ban = %t, want %t", ok, rejected)
+			}
+		})
+	}
+}
+
+func TestHandleHandshakeBannedPeer(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     func(s *syncer) *models.HandshakeMsg
+		banned  bool
+		pending bool
+	}{
+		{
+			name: "compatible",
+			msg: func(s *syncer) *models.HandshakeMsg {
+				return &models.HandshakeMsg{NetworkId: 7, GenesisBlockHash: s.genesisHash()}
+			},
+			pending: true,
+		},
+		{
+			name: "network id",
+			msg: func(s *syncer) *models.HandshakeMsg {
+				return &models.HandshakeMsg{NetworkId: 8, GenesisBlockHash: s.genesisHash()}
+			},
+			banned: true,
+		},
+		{
+			name: "genesis",
+			msg: func(s *syncer) *models.HandshakeMsg {
+				return &models.HandshakeMsg{NetworkId: 7, GenesisBlockHash: types.Hash{1}}
+			},
+			banned: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSyncer(t, newTestChain(), WithNetworkId(7))
+			defer s.Stop()
+
+			const id = models.P2PID("remote")
+			msg := tt.msg(s)
+			msg.Peer = id
+			s.handleHandshake(msg)
+			if banned := s.bans.banned(id); banned != tt.banned {
+				t.Fatalf("banned = %t, want %t", banned, tt.banned)
+			}
+			if pending := s.pendingStatus[id]; pending != tt.pending {
+				t.Fatalf("pending status = %t, want %t", pending, tt.pending)
+			}
+
+			// 被禁止的节点再次handshake时直接断开，不再交换状态
+			delete(s.pendingStatus, id)
+			msg.NetworkId, msg.GenesisBlockHash = 7, s.genesisHash()
+			s.handleHandshake(msg)
+			if pending := s.pendingStatus[id]; pending == tt.banned {
+				t.Fatalf("pending status after retry = %t, want %t", pending, !tt.banned)
+			}
+		})
+	}
+}
+
+func TestHandleStatusBannedPeer(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain(), WithNetworkId(7))
+	defer s.Stop()
+
+	// 被禁止的节点跳过handshake直接发送兼容的状态
+	const id = models.P2PID("remote")
+	s.bans.ban(id, s.now().Add(banDuration))
+	status := s.localStatus()
+	status.Weight = new(big.Int)
+	s.handleStatus(&statusMsg{peer: id, status: status})
+	if s.peers.Peer(id) != nil {
+		t.Fatalf("banned peer registered through status")
+	}
+}
+
+// slowStatusP2P 发送状态消息较慢的p2p服务，并发发送的其他消息会先于状态到达
+type slowStatusP2P struct {
+	*replayP2P
+}
+
+func (p slowStatusP2P) Send(peerId models.P2PID, msg *models.P2PMessage) error {
+	if msg.Type == StatusMsg {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return p.replayP2P.Send(peerId, msg)
+}
+
+func TestStatusReplySentFirst(t *testing.T) {
+	p2p := newReplayP2P(new(mclock.Simulated))
+	s, _ := newTestSyncer(t, newTestChain(), WithP2PService(slowStatusP2P{p2p}))
+	defer s.Stop()
+
+	// 对方先发起状态交换，且链头更高，注册后立即开始请求header
+	const id = models.P2PID("remote")
+	status := s.localStatus()
+	status.Weight = big.NewInt(100)
+	status.CurrentHeight, status.CurrentHash = 10, types.Hash{1}
+	s.handleStatus(&statusMsg{peer: id, status: status})
+	if err := s.RequestHeadersByNumber(id, 1, 1, 0, false); err != nil {
+		t.Fatalf("request headers: %v", err)
+	}
+
+	var sent []uint64
+	for deadline := time.Now().Add(time.Second); len(sent) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %v to new peer, want status and request", sent)
+		}
+		sent = sent[:0]
+		for _, ev := range p2p.sentEvents() {
+			if ev.Peer == id {
+				sent = append(sent, ev.Code)
+			}
+		}
+	}
+	if sent[0] != StatusMsg {
+		t.Fatalf("first message to new peer = %d, want status", sent[0])
+	}
+	for _, code := range sent[1:] {
+		if code == StatusMsg {
+			t.Fatalf("status sent twice: %v", sent)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"fmt"
+	"github.com/chain5j/chain5j-protocol/models"
+	"sort"
+	"sync"
+)
+
+// SyncSourceMode 同步来源的选择方式
+type SyncSourceMode int
+
+const (
+	SyncFromAny     SyncSourceMode = iota // 拒绝列表之外的节点都可作为同步来源
+	SyncFromAllowed                       // 只从允许列表中或回调允许的节点同步
+)
+
+func (mode SyncSourceMode) String() string {
+	switch mode {
+	case SyncFromAny:
+		return "any"
+	case SyncFromAllowed:
+		return "allowed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(mode))
+	}
+}
+
+// SyncSourceFilter 判断不在静态列表中的节点是否可作为同步来源
+type SyncSourceFilter func(id models.P2PID) bool
+
+// SyncSourcePolicy 同步来源的策略。不可作为同步来源的节点仍会注册，只对其提供数据
+type SyncSourcePolicy struct {
+	Mode  SyncSourceMode `json:"mode"`
+	Allow []models.P2PID `json:"allow"` // 允许列表
+	Deny  []models.P2PID `json:"deny"`  // 拒绝列表，优先于允许列表
+}
+
+// syncPolicy 运行时可修改的同步来源策略
+type syncPolicy struct {
+	mode   SyncSourceMode
+	allow  map[models.P2PID]bool
+	deny   map[models.P2PID]bool
+	filter SyncSourceFilter
+	lock   sync.RWMutex
+}
+
+func newSyncPolicy() *syncPolicy {
+	return &syncPolicy{
+		allow: make(map[models.P2PID]bool),
+		deny:  make(map[models.P2PID]bool),
+	}
+}
+
+// allowed 拒绝列表优先，其次为允许列表及回调，都未命中时按模式决定
+func (p *syncPolicy) allowed(id models.P2PID) bool {
+	p.lock.RLock()
+	if p.deny[id] {
+		p.lock.RUnlock()
+		return false
+	}
+	if p.allow[id] {
+		p.lock.RUnlock()
+		return true
+	}
+	mode, filter := p.mode, p.filter
+	p.lock.RUnlock()
+
+	// 回调可能较慢，不在锁内调用
+	if filter != nil {
+		return filter(id)
+	}
+	return mode == SyncFromAny
+}
+
+func (p *syncPolicy) snapshot() SyncSourcePolicy {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	policy := SyncSourcePolicy{Mode: p.mode}
+	for id := range p.allow {
+		policy.Allow = append(policy.Allow, id)
+	}
+	for id := range p.deny {
+		policy.Deny = append(policy.Deny, id)
+	}
+	sort.Slice(policy.Allow, func(i, j int) bool { return policy.Allow[i] < policy.Allow[j] })
+	sort.Slice(policy.Deny, func(i, j int) bool { return policy.Deny[i] < policy.Deny[j] })
+	return policy
+}
+
+// SyncSource 是否可作为同步来源
+func (p *peer) SyncSource() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.syncSource
+}
+
+func (p *peer) setSyncSource(syncSource bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.syncSource = syncSource
+}
+
+// isSyncSource 节点是否已注册且可作为同步来源
+func (s *syncer) isSyncSource(peerId models.P2PID) bool {
+	peer := s.peers.Peer(peerId)
+	return peer != nil && peer.SyncSource()
+}
+
+// refreshSyncSources 策略修改后重新判断已注册的节点
+func (s *syncer) refreshSyncSources() {
+	for _, peer := range s.peers.Peers() {
+		syncSource := s.policy.allowed(peer.P2PID)
+		if syncSource != peer.SyncSource() {
+			s.log.Info("Sync source changed", "peer", peer.P2PID, "syncSource", syncSource)
+			peer.setSyncSource(syncSource)
+		}
+	}
+}
+
+// SyncSources 当前的同步来源策略
+func (s *syncer) SyncSources() SyncSourcePolicy {
+	return s.policy.snapshot()
+}
+
+// SetSyncSourceMode 修改同步来源的选择方式
+func (s *syncer) SetSyncSourceMode(mode SyncSourceMode) error {
+	if mode != SyncFromAny && mode != SyncFromAllowed {
+		return fmt.Errorf("unknown sync source mode: %s", mode)
+	}
+	s.policy.lock.Lock()
+	s.policy.mode = mode
+	s.policy.lock.Unlock()
+
+	s.refreshSyncSources()
+	return nil
+}
+
+// AllowSyncSources 将节点加入允许列表，并从拒绝列表中移除
+func (s *syncer) AllowSyncSources(ids ...models.P2PID) {
+	s.policy.lock.Lock()
+	for _, id := range ids {
+		s.policy.allow[id] = true
+		delete(s.policy.deny, id)
+	}
+	s.policy.lock.Unlock()
+
+	s.refreshSyncSources()
+}
+
+// DenySyncSources 将节点加入拒绝列表，并从允许列表中移除
+func (s *syncer) DenySyncSources(ids ...models.P2PID) {
+	s.policy.lock.Lock()
+	for _, id := range ids {
+		s.policy.deny[id] = true
+		delete(s.policy.allow, id)
+	}
+	s.policy.lock.Unlock()
+
+	s.refreshSyncSources()
+}
+
+// RemoveSyncSources 将节点从允许及拒绝列表中移除
+func (s *syncer) RemoveSyncSources(ids ...models.P2PID) {
+	s.policy.lock.Lock()
+	for _, id := range ids {
+		delete(s.policy.allow, id)
+		delete(s.policy.deny, id)
+	}
+	s.policy.lock.Unlock()
+
+	s.refreshSyncSources()
+}
+
+// SetSyncSourceFilter 设置判断同步来源的回调，为空时按模式决定
+func (s *syncer) SetSyncSourceFilter(filter SyncSourceFilter) {
+	s.policy.lock.Lock()
+	s.policy.filter = filter
+	s.policy.lock.Unlock()
+
+	s.refreshSyncSources()
+}
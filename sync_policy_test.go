@@ -0,0 +1,148 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSyncSourcePolicy(t *testing.T) {
+	filter := func(id models.P2PID) bool { return id == "filtered" }
+	tests := []struct {
+		name    string
+		mode    SyncSourceMode
+		allow   []models.P2PID
+		deny    []models.P2PID
+		filter  SyncSourceFilter
+		allowed map[models.P2PID]bool
+	}{
+		{
+			name:    "any",
+			mode:    SyncFromAny,
+			deny:    []models.P2PID{"denied"},
+			allowed: map[models.P2PID]bool{"other": true, "denied": false},
+		},
+		{
+			name:    "allowed only",
+			mode:    SyncFromAllowed,
+			allow:   []models.P2PID{"allowed"},
+			allowed: map[models.P2PID]bool{"allowed": true, "other": false},
+		},
+		{
+			name:    "deny wins over allow",
+			mode:    SyncFromAllowed,
+			allow:   []models.P2PID{"both"},
+			deny:    []models.P2PID{"both"},
+			allowed: map[models.P2PID]bool{"both": false},
+		},
+		{
+			name:    "filter for unlisted peers",
+			mode:    SyncFromAny,
+			allow:   []models.P2PID{"allowed"},
+			deny:    []models.P2PID{"filtered-denied"},
+			filter:  filter,
+			allowed: map[models.P2PID]bool{"allowed": true, "filtered": true, "other": false, "filtered-denied": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSyncer(t, newTestChain(), WithSyncSources(tt.mode, tt.allow, tt.deny), WithSyncSourceFilter(tt.filter))
+			defer s.Stop()
+
+			for id, want := range tt.allowed {
+				if got := s.policy.allowed(id); got != want {
+					t.Fatalf("allowed(%s) = %t, want %t", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncSourcePolicyRefreshesPeers(t *testing.T) {
+	s, _ := newTestSyncer(t, newTestChain(), WithSyncSources(SyncFromAllowed, nil, nil))
+	defer s.Stop()
+
+	const id = models.P2PID("remote")
+	status := s.localStatus()
+	status.Weight = new(big.Int)
+	s.handleStatus(&statusMsg{peer: id, status: status})
+	if s.peers.Peer(id) == nil || s.isSyncSource(id) {
+		t.Fatalf("peer outside allow list should be registered as serve-only")
+	}
+
+	steps := []struct {
+		name   string
+		update func()
+		source bool
+	}{
+		{name: "allow", update: func() { s.AllowSyncSources(id) }, source: true},
+		{name: "deny", update: func() { s.DenySyncSources(id) }},
+		{name: "remove", update: func() { s.RemoveSyncSources(id) }},
+		{name: "any mode", update: func() { s.SetSyncSourceMode(SyncFromAny) }, source: true},
+		{name: "filter", update: func() { s.SetSyncSourceFilter(func(models.P2PID) bool { return false }) }},
+	}
+	for _, step := range steps {
+		step.update()
+		if source := s.isSyncSource(id); source != step.source {
+			t.Fatalf("after %s: sync source = %t, want %t", step.name, source, step.source)
+		}
+	}
+}
+
+func TestServeOnlyPeer(t *testing.T) {
+	chain := newTestChain()
+	chain.extend(testHeaders(chain.GetHeaderByNumber(0), 2, 0))
+	const id = models.P2PID("serve-only")
+	s, p2p := newTestSyncer(t, chain, WithSyncSources(SyncFromAny, nil, []models.P2PID{id}))
+	defer s.Stop()
+	drainCompleted(s)
+
+	status := s.localStatus()
+	status.Weight = new(big.Int)
+	s.handleStatus(&statusMsg{peer: id, status: status})
+	peer := s.peers.Peer(id)
+	if peer == nil || peer.SyncSource() {
+		t.Fatalf("denied peer should be registered as serve-only")
+	}
+
+	// 不作为同步来源：不参与节点选择，主动推送的header不导入
+	if selected := s.peers.BestPeerExcept(0, nil); selected != nil {
+		t.Fatalf("selected serve-only peer %s", selected.P2PID)
+	}
+	headers := testHeaders(chain.CurrentHeader(), 2, 0)
+	if err := s.HandleBlockHeadersMsg(id, headers); err != nil {
+		t.Fatalf("handle headers: %v", err)
+	}
+	s.queueLock.Lock()
+	queued := len(s.queues)
+	s.queueLock.Unlock()
+	if queued != 0 {
+		t.Fatalf("queued %d headers from serve-only peer", queued)
+	}
+
+	// 仍对其提供数据
+	request, err := s.encodeMsg(id, GetBlockHeadersMsg, &ext.GetBlockHeadersData{Origin: ext.HashOrNumber{Number: 1}, Amount: 2})
+	if err != nil {
+		t.Fatalf("encode request: %v", err)
+	}
+	if err := s.handleMsg(&models.P2PMessage{Type: GetBlockHeadersMsg, Peer: id, Data: request}); err != nil {
+		t.Fatalf("handle request: %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		var served bool
+		for _, ev := range p2p.sentEvents() {
+			served = served || ev.Peer == id && ev.Code == BlockHeadersMsg
+		}
+		if served {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request from serve-only peer not answered")
+		}
+	}
+}
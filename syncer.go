@@ -5,9 +5,13 @@ package syncer
 
 import (
 	"context"
+	"github.com/chain5j/chain5j-pkg/event"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-pkg/types"
 	"github.com/chain5j/chain5j-protocol/models"
 	"github.com/chain5j/chain5j-protocol/protocol"
 	"github.com/chain5j/logger"
+	"sync"
 	"time"
 )
 
@@ -16,8 +20,9 @@ var (
 )
 
 const (
-	forceSyncCycle       = 10 * time.Second // 强制同步的间隔时间
-	forceSyncHeaderCycle = 3 * time.Minute  // 强制同步Header的间隔时间
+	forceSyncCycle          = 10 * time.Second // 强制同步的间隔时间
+	staleHeadCheckCycle     = 30 * time.Second // 检查链头过期节点的间隔时间
+	defaultStaleHeadTimeout = 3 * time.Minute  // 链头超过该时间未更新的节点需重新handshake
 )
 
 type syncer struct {
@@ -27,14 +32,64 @@ type syncer struct {
 
 	p2p       protocol.P2PService
 	apps      protocol.Apps
+	txPools   protocol.TxPools // 接收到的交易放入交易池，可为空
 	blockRW   protocol.BlockReadWriter
 	handshake protocol.Handshake
 
+	mode        SyncMode     // 同步模式
+	headerStore HeaderStore  // 轻节点的header存储
+	fetcher     *fetcher     // 已发出的请求
+	sources     *multiSource // 区块来源，p2p为最后的默认来源
+
+	synchronising int32 // 是否在同步中
+
+	weigher  ChainWeigher   // 链权重
+	compare  HeadComparator // 链头比较器
+	selector PeerSelector   // 下载及按需获取时的节点选择策略
+	policy   *syncPolicy    // 同步来源的允许及拒绝列表
+	control  syncControl    // 暂停及同步目标
+
+	finality   FinalityMode   // 对区块最终性的要求
+	validators *validatorSets // 提交证书的校验及验证者集合
+
+	backfill    *backfill   // 从可信检查点往创世块方向回填
+	historyFrom uint64      // 本节点可提供完整区块的最低高度
+	compression Compression // 支持的压缩算法
+	codecs      *wireCodecs // 支持的消息编码
+
+	stateStore protocol.KVStore // 同步状态的持久化存储
+	stateLock  sync.Mutex
+	bans       *peerBans // 被禁止的节点
+	badBlocks  *lruCache // 已知的无效区块 hash==>*BadBlock
+
+	router   *msgRouter   // 入站消息的注册表及分发
+	clock    mclock.Clock // 定时器、请求超时及时间戳使用的时钟
+	epoch    time.Time    // 创建时的时间，与epochAbs一起将clock换算为时间
+	epochAbs mclock.AbsTime
+
+	staleHeadTimeout time.Duration   // 链头超过该时间未更新的节点需重新handshake
+	tracer           *traceRecorder  // 同步追踪记录，可为空
+	replaySteps      chan replayStep // 回放时通知事件处理完成，为空时不通知
+
+	networkId     uint64                // 网络ID
+	genesis       types.Hash            // 创世块hash
+	pendingStatus map[models.P2PID]bool // 已发送状态，等待对方状态的节点
+
 	handshakePeerCh  chan *models.HandshakeMsg
+	statusCh         chan *statusMsg
 	blockCompletedCh chan struct{}
 	peers            *peerSet
 
-	queues map[uint64]*peerBlock // height==>Block
+	serveCacheConfig ServeCacheConfig
+	serveCache       *serveCache
+
+	reorgFeed  event.Feed // 切换分叉的事件
+	reorgScope event.SubscriptionScope
+	reorgs     *reorgQueue // 待发布的切换分叉事件
+
+	queues    map[uint64]*peerBlock // height==>Block
+	queueLock sync.Mutex
+	progress  *progress // 同步进度
 	// knownHashes map[string]uint64     // hash==>height
 
 	syncDrop *time.Timer // Timed connection dropper if sync progress isn't validated in time
@@ -52,30 +107,107 @@ func NewSyncer(rootCtx context.Context, opts ...option) (protocol.Syncer, error)
 		ctx:    ctx,
 		cancel: cancel,
 
+		pendingStatus: make(map[models.P2PID]bool),
+
 		handshakePeerCh:  make(chan *models.HandshakeMsg),
+		statusCh:         make(chan *statusMsg),
 		blockCompletedCh: make(chan struct{}),
 
-		queues: make(map[uint64]*peerBlock),
+		fetcher:  newFetcher(),
+		sources:  new(multiSource),
+		queues:   make(map[uint64]*peerBlock),
+		progress: new(progress),
+		reorgs:   newReorgQueue(),
+
+		badBlocks: newLRUCache(maxBadBlocks),
 		// knownHashes: make(map[string]uint64),
 
-		peers:  newPeerSet(),
-		quitCh: make(chan struct{}),
+		policy:           newSyncPolicy(),
+		router:           newMsgRouter(),
+		codecs:           newWireCodecs(),
+		peers:            newPeerSet(),
+		serveCacheConfig: DefaultServeCacheConfig,
+		staleHeadTimeout: defaultStaleHeadTimeout,
+		quitCh:           make(chan struct{}),
+	}
+	if err := s.router.register(syncHandlers()...); err != nil {
+		return nil, err
 	}
 	if err := apply(s, opts...); err != nil {
 		s.log.Error("apply is error", "err", err)
 		return nil, err
 	}
+	if s.mode == LightSync && s.headerStore == nil {
+		return nil, errNoHeaderStore
+	}
+	if s.mode == FullSync && s.blockRW == nil {
+		return nil, errNoBlockRW
+	}
+	if s.backfill != nil && s.mode != FullSync {
+		return nil, errBackfillLightMode
+	}
+	if s.finality != FinalityOff && s.validators == nil {
+		return nil, errNoFinalityVerifier
+	}
+	if s.compare == nil {
+		s.compare = CompareByWeight
+	}
+	if s.clock == nil {
+		s.clock = mclock.System{}
+	}
+	s.epoch, s.epochAbs = time.Now(), s.clock.Now()
+	s.bans = newPeerBans(s.now)
+	if s.tracer != nil {
+		s.tracer.setClock(s.clock)
+		s.p2p = &tracingP2P{P2PService: s.p2p, tracer: s.tracer, quitCh: s.quitCh}
+		s.handshake = &tracingHandshake{Handshake: s.handshake, tracer: s.tracer, quitCh: s.quitCh}
+	}
+	s.peers.compare = s.compare
+	if s.selector == nil {
+		s.selector = SelectByHead(s.compare)
+	}
+	s.peers.selector = s.selector
+	s.sources.log = s.log
+	s.sources.sources = append(s.sources.sources, &p2pSource{s: s})
+	s.serveCache = newServeCache(s.serveCacheConfig)
+	if s.blockRW != nil {
+		s.serveCache.blockRW = s.blockRW
+	} else {
+		s.serveCache.blockRW = headerServeStore{s.headerStore}
+	}
 	return s, nil
 }
 
+// now 按s.clock计算的当前时间，回放时由模拟时钟决定
+func (s *syncer) now() time.Time {
+	return s.epoch.Add(time.Duration(s.clock.Now() - s.epochAbs))
+}
+
 func (s *syncer) Start() error {
+	// 恢复上次的同步状态
+	if err := s.loadState(); err != nil {
+		s.log.Error("load sync state err", "err", err)
+		return err
+	}
 	go s.syncBlocks()
 	go s.listen()
+	// 只有header存储的轻节点没有链头事件
+	if s.blockRW != nil {
+		go s.chainHeadLoop()
+	}
+	go s.reorgLoop()
+	if s.backfill != nil && !s.Backfill().Done {
+		go s.backfillLoop()
+	}
 	return nil
 }
 
 func (s *syncer) Stop() error {
+	s.saveState()
 	close(s.quitCh)
+	s.cancel()
+	s.peers.Close()
+	s.reorgScope.Close()
 	// close(s.handshakePeerCh)
 	return nil
 }
@@ -89,39 +221,40 @@ func (s *syncer) syncBlocks() {
 	// 协议订阅
 	handshakePeerSub := s.handshake.SubscribeHandshake(s.handshakePeerCh)
 
-	forceSync := time.NewTicker(forceSyncCycle)
-	defer forceSync.Stop()
-	forceSyncHeader := time.NewTicker(forceSyncHeaderCycle)
-	defer forceSyncHeader.Stop()
+	// 定时器使用s.clock，回放时由模拟时钟触发
+	forceSync := s.clock.After(forceSyncCycle)
+	staleHeadCheck := s.clock.After(staleHeadCheckCycle)
 
 	for {
 		select {
 		case ch := <-dropPeerCh:
+			delete(s.pendingStatus, ch)
 			s.peers.Deregister(ch)
+			s.router.forget(ch)
+			s.replayed(TraceDrop, 0)
 		case err := <-dropPeerSub.Err():
 			s.log.Error("dropPeerSub", "err", err)
 		case ch := <-s.handshakePeerCh:
-			// TODO 有新的节点进来，就会直接调用同步接口
-			peer := s.peers.Peer(ch.Peer)
-			if peer != nil {
-				peer.SetHead(ch.CurrentBlockHash, ch.CurrentBlockHeight)
-				break
-			}
-			peer = newPeer(s.p2p, ch.Peer)
-			s.peers.Register(peer, nil)
-
-			peer.SetHead(ch.CurrentBlockHash, ch.CurrentBlockHeight)
-
-			go s.syncBlocksLoop(peer)
+			// 新节点需交换状态，校验通过后才会注册并同步
+			s.handleHandshake(ch)
+			s.replayed(TraceHandshake, 0)
+		case ch := <-s.statusCh:
+			s.handleStatus(ch)
 		case err := <-handshakePeerSub.Err():
 			s.log.Error("handshakePeerSub", "err", err)
 		case <-s.blockCompletedCh:
 			s.blockCompleted()
-		case <-forceSync.C:
-			// 强制执行同步时，选择对方高度最高的进行同步
-			go s.syncBlocksLoop(s.peers.BestPeer())
-		case <-forceSyncHeader.C:
-			go s.syncBlocksHeaderLoop(s.peers)
+		case <-forceSync:
+			s.tracer.record(TraceTimer, traceTimerForceSync, "", nil)
+			forceSync = s.clock.After(forceSyncCycle)
+			// 强制执行同步时，以各个来源中的最高高度为目标进行同步
+			go s.syncWithSources()
+			s.replayed(TraceTimer, traceTimerForceSync)
+		case <-staleHeadCheck:
+			s.tracer.record(TraceTimer, traceTimerStaleHeads, "", nil)
+			staleHeadCheck = s.clock.After(staleHeadCheckCycle)
+			s.requestStaleHeads()
+			s.replayed(TraceTimer, traceTimerStaleHeads)
 		case <-s.quitCh:
 			return
 		}
@@ -129,31 +262,19 @@ func (s *syncer) syncBlocks() {
 }
 
 func (s *syncer) syncBlocksLoop(peer *peer) {
-	if peer == nil {
+	// 只对其提供数据的节点不作为同步来源
+	if peer == nil || !peer.SyncSource() {
 		return
 	}
 
-	currentBlock := s.blockRW.CurrentBlock()
-	_, pHeight := peer.Head()
-	if pHeight <= currentBlock.Height() {
+	// 只有对方的链头优于本地时才进行同步
+	head := peer.ChainHead()
+	if s.compare(head, s.localHead()) <= 0 {
 		return
 	}
 	// 开始进行同步下载
-	// TODO 计算local height和远程的相隔，然后获取其间隔，如果相隔较近
-	s.findAncestor(peer, pHeight)
-
-}
-
-// 每10分钟进行一次全局的peer的handshake
-func (s *syncer) syncBlocksHeaderLoop(peers *peerSet) {
-	if peers == nil || len(peers.peers) == 0 {
-		return
-	}
-	for _, peer := range peers.peers {
-		if peer == nil {
-			return
-		}
-		go s.handshake.RequestHandshake(peer.P2PID)
+	if err := s.synchronise(string(peer.P2PID), head); err != nil && err != errBusy {
+		s.log.Warn("synchronise err", "peer", peer.P2PID, "target", head.Height, "err", err)
 	}
 }
 
@@ -162,15 +283,26 @@ func (s *syncer) blockCompleted() {
 	next := block.Height()
 	for {
 		next = next + 1
+		s.queueLock.Lock()
 		qBlock := s.queues[next]
-		if qBlock == nil {
-			return
-		}
-		if qBlock.syncing {
+		if qBlock == nil || qBlock.syncing {
+			s.queueLock.Unlock()
 			return
 		}
 		delete(s.queues, next)
+		s.queueLock.Unlock()
+
 		s.log.Debug("blockCompleted", "height", next)
-		s.blockRW.ProcessBlock(qBlock.Block, false)
+		if err := s.blockRW.ProcessBlock(qBlock.Block, false); err != nil {
+			s.log.Error("process block err", "height", next, "hash", qBlock.Hash(), "err", err)
+			// 只有校验失败才记为无效区块并惩罚来源，本地错误不影响区块及节点
+			if isInvalidBlock(err) {
+				s.markBadBlock(qBlock.Hash(), next, err.Error(), qBlock.source)
+				s.penalize(models.P2PID(qBlock.source), err)
+			}
+			s.progress.addFailed()
+			return
+		}
+		s.progress.addImported()
 	}
 }
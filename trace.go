@@ -0,0 +1,211 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-pkg/event"
+	"github.com/chain5j/chain5j-pkg/mclock"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/protocol"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	traceMagic        = "C5JTRACE"
+	maxTraceEventSize = 64 * 1024 * 1024
+)
+
+// 追踪事件类型
+const (
+	TraceInbound   uint8 = iota // 接收到的消息
+	TraceOutbound               // 发送的消息
+	TraceHandshake              // handshake事件
+	TraceDrop                   // 节点断开事件
+	TraceTimer                  // 定时器触发
+)
+
+// 定时器编号
+const (
+	traceTimerForceSync uint64 = iota
+	traceTimerStaleHeads
+)
+
+var errInvalidTrace = errors.New("invalid sync trace")
+
+// TraceEvent 同步追踪中的一个事件
+type TraceEvent struct {
+	Time uint64       // 距离开始记录的时间，纳秒
+	Kind uint8        // 事件类型
+	Code uint64       // 消息类型或定时器编号
+	Peer models.P2PID // 节点ID
+	Data []byte       // 消息数据，handshake事件为编码后的HandshakeMsg
+}
+
+// traceRecorder 将同步相关的事件写入追踪文件
+type traceRecorder struct {
+	w     *bufio.Writer
+	clock mclock.Clock
+	start mclock.AbsTime
+	err   error
+	lock  sync.Mutex
+}
+
+func newTraceRecorder(w io.Writer) (*traceRecorder, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(traceMagic); err != nil {
+		return nil, err
+	}
+	return &traceRecorder{w: bw}, bw.Flush()
+}
+
+// setClock 设置时钟并开始计时
+func (t *traceRecorder) setClock(clock mclock.Clock) {
+	t.clock, t.start = clock, clock.Now()
+}
+
+// record 记录事件，写入失败后不再记录
+func (t *traceRecorder) record(kind uint8, code uint64, peer models.P2PID, data []byte) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.err != nil {
+		return
+	}
+	ev := &TraceEvent{
+		Time: uint64(t.clock.Now() - t.start),
+		Kind: kind,
+		Code: code,
+		Peer: peer,
+		Data: data,
+	}
+	bytes, err := codec.Coder().Encode(ev)
+	if err == nil {
+		if err = writeArchiveItem(t.w, bytes); err == nil {
+			err = t.w.Flush()
+		}
+	}
+	t.err = err
+}
+
+// ReadTrace 读取追踪文件中的全部事件
+func ReadTrace(r io.Reader) ([]*TraceEvent, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != traceMagic {
+		return nil, errInvalidTrace
+	}
+	var events []*TraceEvent
+	for {
+		bytes, err := readArchiveItem(br, maxTraceEventSize)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidTrace, err)
+		}
+		ev := new(TraceEvent)
+		if err := codec.Coder().Decode(bytes, ev); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidTrace, err)
+		}
+		events = append(events, ev)
+	}
+}
+
+// tracingP2P 记录收发消息及节点断开事件的p2p服务
+type tracingP2P struct {
+	protocol.P2PService
+	tracer *traceRecorder
+	quitCh chan struct{}
+}
+
+func (p *tracingP2P) Send(peerId models.P2PID, msg *models.P2PMessage) error {
+	p.tracer.record(TraceOutbound, uint64(msg.Type), peerId, msg.Data)
+	return p.P2PService.Send(peerId, msg)
+}
+
+func (p *tracingP2P) SubscribeMsg(msgType uint, ch chan<- *models.P2PMessage) event.Subscription {
+	inner := make(chan *models.P2PMessage, cap(ch))
+	sub := p.P2PService.SubscribeMsg(msgType, inner)
+	go func() {
+		for {
+			select {
+			case msg := <-inner:
+				p.tracer.record(TraceInbound, uint64(msg.Type), msg.Peer, msg.Data)
+				select {
+				case ch <- msg:
+				case <-p.quitCh:
+					return
+				}
+			case <-p.quitCh:
+				return
+			}
+		}
+	}()
+	return sub
+}
+
+func (p *tracingP2P) SubscribeDropPeer(ch chan<- models.P2PID) event.Subscription {
+	inner := make(chan models.P2PID, cap(ch))
+	sub := p.P2PService.SubscribeDropPeer(inner)
+	go func() {
+		for {
+			select {
+			case id := <-inner:
+				p.tracer.record(TraceDrop, 0, id, nil)
+				select {
+				case ch <- id:
+				case <-p.quitCh:
+					return
+				}
+			case <-p.quitCh:
+				return
+			}
+		}
+	}()
+	return sub
+}
+
+// tracingHandshake 记录handshake事件
+type tracingHandshake struct {
+	protocol.Handshake
+	tracer *traceRecorder
+	quitCh chan struct{}
+}
+
+func (h *tracingHandshake) SubscribeHandshake(ch chan *models.HandshakeMsg) event.Subscription {
+	inner := make(chan *models.HandshakeMsg, cap(ch))
+	sub := h.Handshake.SubscribeHandshake(inner)
+	go func() {
+		for {
+			select {
+			case msg := <-inner:
+				if bytes, err := codec.Coder().Encode(msg); err == nil {
+					h.tracer.record(TraceHandshake, 0, msg.Peer, bytes)
+				}
+				select {
+				case ch <- msg:
+				case <-h.quitCh:
+					return
+				}
+			case <-h.quitCh:
+				return
+			}
+		}
+	}()
+	return sub
+}
+
+// traceElapsed 事件距离开始记录的时间
+func (ev *TraceEvent) traceElapsed() time.Duration {
+	return time.Duration(ev.Time)
+}
@@ -10,12 +10,14 @@ import (
 // ==============peerBlock=============
 type peerBlock struct {
 	*models.Block
-	syncing bool //是否在同步中
+	syncing bool   //是否在同步中
+	source  string // 区块的来源
 }
 
 func NewPeerBlock(header *models.Header) *peerBlock {
+	// NewBlock会按空交易重置交易根，需用原始header重新封装
 	return &peerBlock{
-		Block:   models.NewBlock(header, nil, nil),
+		Block:   models.NewBlock(header, nil, nil).WithSeal(header),
 		syncing: true,
 	}
 }
@@ -0,0 +1,71 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/types"
+	"github.com/chain5j/chain5j-protocol/models"
+	"math/big"
+)
+
+// ChainHead 链头信息
+type ChainHead struct {
+	Hash   types.Hash `json:"hash"`   // 链头hash
+	Height uint64     `json:"height"` // 链头高度
+	Weight *big.Int   `json:"weight"` // 共识定义的链权重，为空或0时表示未知
+}
+
+// hasWeight 是否有可用的链权重
+func (h ChainHead) hasWeight() bool {
+	return h.Weight != nil && h.Weight.Sign() > 0
+}
+
+// ChainWeigher 共识定义的链权重，如PoW的总难度或PoS的最终性得分
+type ChainWeigher interface {
+	// Weight 以header为链头的链权重
+	Weight(header *models.Header) *big.Int
+}
+
+// HeadComparator 链头比较器，a优于b时返回值大于0，相同返回0，否则小于0
+type HeadComparator func(a, b ChainHead) int
+
+// CompareByWeight 默认的比较器，双方都有链权重时比较权重，否则比较高度
+func CompareByWeight(a, b ChainHead) int {
+	if a.hasWeight() && b.hasWeight() {
+		if c := a.Weight.Cmp(b.Weight); c != 0 {
+			return c
+		}
+	}
+	return CompareByHeight(a, b)
+}
+
+// CompareByHeight 只比较高度
+func CompareByHeight(a, b ChainHead) int {
+	switch {
+	case a.Height > b.Height:
+		return 1
+	case a.Height < b.Height:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// localHead 本地链头
+func (s *syncer) localHead() ChainHead {
+	var header *models.Header
+	if s.mode == LightSync {
+		header = s.headerStore.CurrentHeader()
+	} else {
+		header = s.blockRW.CurrentBlock().Header()
+	}
+	head := ChainHead{
+		Hash:   header.Hash(),
+		Height: header.Height,
+	}
+	if s.weigher != nil {
+		head.Weight = s.weigher.Weight(header)
+	}
+	return head
+}
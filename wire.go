@@ -0,0 +1,135 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-protocol/models"
+	"sort"
+)
+
+// CodecVersion 消息编码的版本号。双方都支持版本号时，每条消息以1字节的版本号开头，
+// 不同编码的节点可在滚动升级期间共存
+type CodecVersion uint8
+
+// CodecRLP 默认编码，使用全局的codec.Coder()，不支持版本号的节点之间始终使用该编码
+const CodecRLP CodecVersion = 0
+
+var (
+	errUnknownCodec  = errors.New("unknown wire codec")
+	errReservedCodec = errors.New("wire codec version is reserved")
+)
+
+// wireFormat 与某个节点通信使用的编码
+type wireFormat struct {
+	version   CodecVersion // 编码版本号
+	versioned bool         // 消息是否带版本号
+}
+
+// wireCodecs 本节点支持的编码
+type wireCodecs struct {
+	codecs    map[CodecVersion]codec.Codec
+	preferred CodecVersion // 发送时优先使用的编码
+}
+
+func newWireCodecs() *wireCodecs {
+	return &wireCodecs{codecs: map[CodecVersion]codec.Codec{CodecRLP: codec.Coder()}}
+}
+
+// versions 支持的编码版本号
+func (w *wireCodecs) versions() []uint8 {
+	list := make([]uint8, 0, len(w.codecs))
+	for version := range w.codecs {
+		list = append(list, uint8(version))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+func (w *wireCodecs) codec(version CodecVersion) (codec.Codec, error) {
+	c, ok := w.codecs[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", errUnknownCodec, version)
+	}
+	return c, nil
+}
+
+// negotiateCodec 对方支持本节点优先的编码时使用该编码，否则使用RLP
+func (s *syncer) negotiateCodec(status *statusData) wireFormat {
+	if status.Capabilities&CapCodecVersion == 0 {
+		return wireFormat{}
+	}
+	for _, version := range status.Codecs {
+		if CodecVersion(version) == s.codecs.preferred {
+			return wireFormat{version: s.codecs.preferred, versioned: true}
+		}
+	}
+	return wireFormat{version: CodecRLP, versioned: true}
+}
+
+// wireFormat 与节点通信使用的编码，未完成状态交换的节点使用不带版本号的RLP
+func (s *syncer) wireFormat(peerId models.P2PID) wireFormat {
+	if peer := s.peers.Peer(peerId); peer != nil {
+		return peer.WireFormat()
+	}
+	return wireFormat{}
+}
+
+// marshal 按编码版本编码消息
+func (s *syncer) marshal(format wireFormat, v interface{}) ([]byte, error) {
+	c, err := s.codecs.codec(format.version)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encode(v)
+}
+
+// frame 压缩编码后的消息，并在需要时加上版本号
+func (s *syncer) frame(peerId models.P2PID, code uint64, format wireFormat, data []byte) []byte {
+	data = s.encodePayload(peerId, code, data)
+	if format.versioned {
+		data = append([]byte{byte(format.version)}, data...)
+	}
+	return data
+}
+
+// encodeMsg 按与节点协商的编码编码消息
+func (s *syncer) encodeMsg(peerId models.P2PID, code uint64, v interface{}) ([]byte, error) {
+	format := s.wireFormat(peerId)
+	data, err := s.marshal(format, v)
+	if err != nil {
+		return nil, err
+	}
+	return s.frame(peerId, code, format, data), nil
+}
+
+// sendMsg 编码消息并放入节点的发送队列
+func (s *syncer) sendMsg(peerId models.P2PID, code uint64, v interface{}) error {
+	data, err := s.encodeMsg(peerId, code, v)
+	if err != nil {
+		return err
+	}
+	return s.send(peerId, &models.P2PMessage{
+		Type: uint(code),
+		Peer: "",
+		Data: data,
+	})
+}
+
+// unframe 去掉消息的版本号，返回对应的编码
+func (s *syncer) unframe(peerId models.P2PID, data []byte) (codec.Codec, []byte, error) {
+	if !s.wireFormat(peerId).versioned {
+		return s.codecs.codecs[CodecRLP], data, nil
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: missing version", errUnknownCodec)
+	}
+	c, err := s.codecs.codec(CodecVersion(data[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, data[1:], nil
+}
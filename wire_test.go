@@ -0,0 +1,73 @@
+// Package syncer
+//
+// @author: xwc1125
+package syncer
+
+import (
+	"github.com/chain5j/chain5j-pkg/codec"
+	"github.com/chain5j/chain5j-protocol/models"
+	"github.com/chain5j/chain5j-protocol/models/ext"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// waitSubscribed 等待syncer完成所有消息的订阅
+func waitSubscribed(t *testing.T, s *syncer, p2p *replayP2P) {
+	t.Helper()
+	codes := make(map[uint]bool)
+	for _, code := range s.router.codes {
+		codes[uint(code)] = true
+	}
+	for deadline := time.Now().Add(replaySubscribeTimeout); !p2p.subscribed(codes); {
+		if time.Now().After(deadline) {
+			t.Fatal(errReplaySubscribe)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStatusAppliesWireFormatBeforeNextMessage(t *testing.T) {
+	s, p2p := newTestSyncer(t, newTestChain())
+	if err := s.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer s.Stop()
+	waitSubscribed(t, s, p2p)
+
+	const id = models.P2PID("remote")
+	status := s.localStatus()
+	status.Weight = new(big.Int)
+	data, err := codec.Coder().Encode(status)
+	if err != nil {
+		t.Fatalf("encode status: %v", err)
+	}
+	request, err := codec.Coder().Encode(&ext.GetBlockHeadersData{Origin: ext.HashOrNumber{Number: 0}, Amount: 1})
+	if err != nil {
+		t.Fatalf("encode request: %v", err)
+	}
+
+	// 对方在状态之后立即发送带版本号的请求，请求须按协商的编码解码
+	p2p.deliver(&models.P2PMessage{Type: StatusMsg, Peer: id, Data: data})
+	p2p.deliver(&models.P2PMessage{Type: GetBlockHeadersMsg, Peer: id, Data: append([]byte{byte(CodecRLP)}, request...)})
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		var answered bool
+		for _, ev := range p2p.sentEvents() {
+			if ev.Peer == id && ev.Code == BlockHeadersMsg {
+				answered = true
+			}
+		}
+		if answered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request after status not answered, stats = %+v", s.MessageStats())
+		}
+	}
+	for _, stats := range s.MessageStats() {
+		if stats.Invalid != 0 {
+			t.Fatalf("%s: %d invalid messages", stats.Name, stats.Invalid)
+		}
+	}
+}